@@ -0,0 +1,223 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+// requireAdmin guards the /admin pages: requests must carry token as a bearer
+// token or as the password of basic auth, which browsers prompt for. The
+// pages are only mounted when there is a token; requests from the machine
+// itself are not trusted either, since on Elastic Beanstalk they all come
+// through the local nginx.
+//
+// Browsers send cached basic auth credentials along with requests that other
+// sites make them send, so requests that change something must also come
+// from the admin pages themselves, as their Sec-Fetch-Site or Origin header
+// tells. Clients other than browsers send neither and are let through.
+func requireAdmin(token string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			_, given, _ = r.BasicAuth()
+		}
+		if token == "" || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="GoAWS admin"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.Method != "GET" && r.Method != "HEAD" && r.Method != "OPTIONS" && crossSite(r) {
+			http.Error(w, "forbidden: cross-site request", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// crossSite tells whether a browser sent r on behalf of another site.
+func crossSite(r *http.Request) bool {
+	if site := r.Header.Get("Sec-Fetch-Site"); site != "" {
+		return site != "same-origin" && site != "none"
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		return err != nil || u.Host != r.Host
+	}
+	return false
+}
+
+// wantsJSON tells whether a client asked for JSON rather than a page.
+func wantsJSON(r *http.Request) bool {
+	return r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+// DLQAdmin serves a list of failed messages at path (/admin/dlq for the dead
+// letters, /admin/quarantine for the invalid messages), their bodies cut to
+// listBodySize, and each of them in full at path/<id>. POSTing ids with action "replay" or "delete" to path replays
+// those messages through the worker or discards them.
+type DLQAdmin struct {
+	path    string
+	title   string
+	worker  *Worker
+	letters *DeadLetters
+}
+
+// listBodySize is how much of a body the list shows.
+const listBodySize = 200
+
+func NewDLQAdmin(path, title string, worker *Worker, letters *DeadLetters) *DLQAdmin {
+	return &DLQAdmin{path: path, title: title, worker: worker, letters: letters}
+}
+
+// ReplayResult is the outcome of replaying or deleting one dead letter.
+type ReplayResult struct {
+	ID    string `json:"id"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+func (a *DLQAdmin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, a.path), "/")
+	switch {
+	case id != "" && r.Method == "GET":
+		a.show(w, r, id)
+	case id == "" && r.Method == "GET":
+		a.list(w, r, nil)
+	case id == "" && r.Method == "POST":
+		a.act(w, r)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (a *DLQAdmin) show(w http.ResponseWriter, r *http.Request, id string) {
+	l, ok := a.letters.Get(id)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(l)
+}
+
+func (a *DLQAdmin) list(w http.ResponseWriter, r *http.Request, results []ReplayResult) {
+	data := struct {
+		Path    string         `json:"-"`
+		Title   string         `json:"-"`
+		Letters []DeadLetter   `json:"letters"`
+		Results []ReplayResult `json:"results,omitempty"`
+	}{a.path, a.title, a.letters.List(), results}
+	for i := range data.Letters {
+		data.Letters[i].Body = truncate(data.Letters[i].Body, listBodySize)
+	}
+
+	if wantsJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(data)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := dlqTemplate.Execute(w, data); err != nil {
+		log.Printf("Cannot render %s: %v\n", a.path, err)
+	}
+}
+
+// truncate cuts s to at most n bytes, not splitting a character, and marks
+// the cut with an ellipsis.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n] + "…"
+}
+
+// act replays or deletes the dead letters picked in the form.
+func (a *DLQAdmin) act(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "bad form", http.StatusBadRequest)
+		return
+	}
+	action, ids := r.PostForm.Get("action"), r.PostForm["id"]
+	if action != "replay" && action != "delete" {
+		http.Error(w, `action must be "replay" or "delete"`, http.StatusBadRequest)
+		return
+	}
+
+	results := make([]ReplayResult, 0, len(ids))
+	for _, id := range ids {
+		var err error
+		if action == "replay" {
+			err = a.worker.Replay(id)
+		} else {
+			if _, ok := a.letters.Get(id); !ok {
+				err = errNoDeadLetter
+			} else if err = a.letters.Remove(id); err == nil {
+				log.Printf("Message %s deleted from %s\n", id, a.path)
+			}
+		}
+
+		result := ReplayResult{ID: id, OK: err == nil}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	a.list(w, r, results)
+}
+
+var dlqTemplate = template.Must(template.New("dlq").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { color: #222222; background-color: #e0ebf5; font-family: Arial, sans-serif; font-size: 14px; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { text-align: left; vertical-align: top; padding: 0.3em 1em; border-bottom: 1px solid #b8cde0; }
+pre { margin: 0; max-width: 40em; max-height: 8em; overflow: auto; white-space: pre-wrap; }
+.ok { color: #2e7d32; }
+.failed { color: #c62828; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{with .Results}}
+<ul>
+{{range .}}<li>{{.ID}}: {{if .OK}}<span class="ok">done</span>{{else}}<span class="failed">{{.Error}}</span>{{end}}</li>
+{{end}}
+</ul>
+{{end}}
+<form method="post" action="{{.Path}}">
+<table>
+<tr><th></th><th>Message</th><th>Type</th><th>Reason</th><th>Failures</th><th>Last failed</th><th>Body</th></tr>
+{{range .Letters}}
+<tr>
+<td><input type="checkbox" name="id" value="{{.ID}}"></td>
+<td><a href="{{$.Path}}/{{.ID}}">{{.ID}}</a></td>
+<td>{{.Type}}</td>
+<td class="failed">{{.Error}}</td>
+<td>{{.Failures}} (receive count {{.ReceiveCount}})</td>
+<td>{{.LastFailed.Format "2006-01-02 15:04:05 MST"}}</td>
+<td><pre>{{.Body}}</pre></td>
+</tr>
+{{else}}
+<tr><td colspan="7">No failed messages.</td></tr>
+{{end}}
+</table>
+<button name="action" value="replay">Replay selected</button>
+<button name="action" value="delete">Delete selected</button>
+</form>
+</body>
+</html>
+`))
@@ -0,0 +1,90 @@
+package main
+
+// The unit tests run from this directory with
+//
+//	go test *.go
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequireAdmin(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		name    string
+		token   string
+		method  string
+		headers map[string]string
+		basic   string
+		remote  string
+		want    int
+	}{
+		{"no token configured", "", "GET", nil, "", "127.0.0.1:1234", http.StatusUnauthorized},
+		{"no token configured, empty bearer", "", "GET", map[string]string{"Authorization": "Bearer "}, "", "127.0.0.1:1234", http.StatusUnauthorized},
+		{"loopback without a token", "secret", "GET", nil, "", "127.0.0.1:1234", http.StatusUnauthorized},
+		{"wrong token", "secret", "GET", map[string]string{"Authorization": "Bearer nope"}, "", "192.0.2.1:1234", http.StatusUnauthorized},
+		{"bearer token", "secret", "GET", map[string]string{"Authorization": "Bearer secret"}, "", "192.0.2.1:1234", http.StatusOK},
+		{"basic auth", "secret", "GET", nil, "secret", "192.0.2.1:1234", http.StatusOK},
+		{"POST without browser headers", "secret", "POST", map[string]string{"Authorization": "Bearer secret"}, "", "192.0.2.1:1234", http.StatusOK},
+		{"same-origin POST", "secret", "POST", map[string]string{"Sec-Fetch-Site": "same-origin"}, "secret", "192.0.2.1:1234", http.StatusOK},
+		{"cross-site POST", "secret", "POST", map[string]string{"Sec-Fetch-Site": "cross-site"}, "secret", "192.0.2.1:1234", http.StatusForbidden},
+		{"same-site POST", "secret", "POST", map[string]string{"Sec-Fetch-Site": "same-site"}, "secret", "192.0.2.1:1234", http.StatusForbidden},
+		{"POST from the same origin", "secret", "POST", map[string]string{"Origin": "http://example.com"}, "secret", "192.0.2.1:1234", http.StatusOK},
+		{"POST from another origin", "secret", "POST", map[string]string{"Origin": "https://evil.example"}, "secret", "192.0.2.1:1234", http.StatusForbidden},
+		{"cross-site GET", "secret", "GET", map[string]string{"Sec-Fetch-Site": "cross-site"}, "secret", "192.0.2.1:1234", http.StatusOK},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, "http://example.com/admin/dlq", nil)
+		r.RemoteAddr = tt.remote
+		for k, v := range tt.headers {
+			r.Header.Set(k, v)
+		}
+		if tt.basic != "" {
+			r.SetBasicAuth("admin", tt.basic)
+		}
+		w := httptest.NewRecorder()
+		requireAdmin(tt.token, ok).ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
+
+func TestDLQListTruncatesBodies(t *testing.T) {
+	wk := newTestWorker(t, logMessage, nil)
+	body := `{"type":"log","payload":"` + strings.Repeat("é", listBodySize) + `"}`
+	if err := wk.dead.Fail(Message{ID: "m1"}, []byte(body), errors.New("boom")); err != nil {
+		t.Fatal(err)
+	}
+	admin := NewDLQAdmin("/admin/dlq", "Dead letters", wk, wk.dead)
+
+	w := httptest.NewRecorder()
+	admin.ServeHTTP(w, httptest.NewRequest("GET", "/admin/dlq?format=json", nil))
+	var list struct{ Letters []DeadLetter }
+	if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Letters) != 1 {
+		t.Fatalf("listed %d letters, want 1", len(list.Letters))
+	}
+	got := list.Letters[0].Body
+	if len(got) > listBodySize+len("…") || !strings.HasSuffix(got, "…") || !strings.HasPrefix(body, strings.TrimSuffix(got, "…")) {
+		t.Errorf("listed body = %q, want the start of the body", got)
+	}
+
+	w = httptest.NewRecorder()
+	admin.ServeHTTP(w, httptest.NewRequest("GET", "/admin/dlq/m1", nil))
+	var l DeadLetter
+	if err := json.NewDecoder(w.Body).Decode(&l); err != nil {
+		t.Fatal(err)
+	}
+	if l.Body != body {
+		t.Errorf("shown body = %q, want it whole", l.Body)
+	}
+}
@@ -1,40 +1,198 @@
 package main
 
 import (
-    "io/ioutil"
-    "log"
-    "net/http"
-    "os"
+	"context"
+	"embed"
+	"expvar"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// The site is built into the binary, so it does not depend on the working
+// directory, along with its Brotli encodings (see static.go).
+var (
+	//go:embed public
+	publicFiles embed.FS
+	//go:embed public-br
+	publicBrotli embed.FS
 )
 
 func main() {
-    port := os.Getenv("PORT")
-    if port == "" {
-	port = "5000"
-    }
-
-    f, _ := os.Create("/var/log/golang/golang-server.log")
-    defer f.Close()
-    log.SetOutput(f)
-
-    const indexPage = "public/index.html"
-    http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-	if r.Method == "POST" {
-	    if buf, err := ioutil.ReadAll(r.Body); err == nil {
-		log.Printf("Received message: %s\n", string(buf))
-	    }
+	if err := serve(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// serve runs the application until it gets SIGTERM, as on a deploy, or an
+// interrupt. It then drains the worker and returns, letting the deferred
+// calls close the files and flush the logs.
+func serve() error {
+	config, err := LoadConfig(os.Args[1:])
+	if err != nil {
+		return err
+	}
+	closeLog := SetupLogging(LogConfigFrom(config))
+	defer closeLog()
+
+	var (
+		port           = config.Get("PORT")
+		processedFile  = config.Get("PROCESSED_FILE")
+		deadLetterFile = config.Get("DEAD_LETTER_FILE")
+		quarantineFile = config.Get("QUARANTINE_FILE")
+		historyFile    = config.Get("TASK_HISTORY_FILE")
+		cronFile       = config.Get("CRON_FILE")
+		adminToken     = config.Get("ADMIN_TOKEN")
+	)
+
+	processed, err := OpenProcessedSet(processedFile)
+	if err != nil {
+		return fmt.Errorf("cannot open %s: %v", processedFile, err)
+	}
+	defer processed.Close()
+
+	registry := NewRegistry()
+	registry.Register("log", logMessage)
+	if err := LoadSchemas(config.Get("SCHEMA_DIR"), registry); err != nil {
+		return fmt.Errorf("cannot load the schemas: %v", err)
+	}
+	dead, err := OpenDeadLetters(deadLetterFile)
+	if err != nil {
+		return fmt.Errorf("cannot open %s: %v", deadLetterFile, err)
+	}
+	defer dead.Close()
+	quarantine, err := OpenDeadLetters(quarantineFile)
+	if err != nil {
+		return fmt.Errorf("cannot open %s: %v", quarantineFile, err)
+	}
+	defer quarantine.Close()
+	pool := NewPool(config.Int("WORKER_CONCURRENCY"), config.Int("WORKER_QUEUE"), config.Duration("WORKER_QUEUE_WAIT"))
+	worker := NewWorker(registry, processed, dead, quarantine, pool, config.Duration("MESSAGE_TIMEOUT"))
+
+	if adminToken != "" {
+		dlq := requireAdmin(adminToken, NewDLQAdmin("/admin/dlq", "Dead letters", worker, dead))
+		http.Handle("/admin/dlq", dlq)
+		http.Handle("/admin/dlq/", dlq)
+		quarantined := requireAdmin(adminToken, NewDLQAdmin("/admin/quarantine", "Quarantined messages", worker, quarantine))
+		http.Handle("/admin/quarantine", quarantined)
+		http.Handle("/admin/quarantine/", quarantined)
+		http.Handle("/admin/metrics", requireAdmin(adminToken, expvar.Handler()))
+		http.Handle("/admin/config", requireAdmin(adminToken, config))
 	} else {
-	    log.Printf("Serving %s to %s...\n", indexPage, r.RemoteAddr)
-	    http.ServeFile(w, r, indexPage)
+		log.Printf("ADMIN_TOKEN is not set, the /admin pages are disabled\n")
 	}
-    })
 
-    http.HandleFunc("/scheduled", func(w http.ResponseWriter, r *http.Request){
-	if r.Method == "POST" {
-	    log.Printf("Received task %s scheduled at %s\n", r.Header.Get("X-Aws-Sqsd-Taskname"), r.Header.Get("X-Aws-Sqsd-Scheduled-At"))
+	public, _ := fs.Sub(publicFiles, "public")
+	brotli, _ := fs.Sub(publicBrotli, "public-br")
+	site, err := NewStaticSite(public, brotli)
+	if err != nil {
+		return fmt.Errorf("cannot load the site: %v", err)
+	}
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			worker.ServeHTTP(w, r)
+		} else {
+			log.Printf("Serving %s to %s...\n", r.URL.Path, r.RemoteAddr)
+			site.ServeHTTP(w, r)
+		}
+	})
+
+	history, err := OpenTaskHistory(historyFile)
+	if err != nil {
+		return fmt.Errorf("cannot open %s: %v", historyFile, err)
+	}
+	defer history.Close()
+
+	tasks := NewTaskRegistry(history)
+	tasks.Register("task1", time.Minute, runTask1)
+
+	entries, err := ReadCronFile(cronFile)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("cannot read %s: %v", cronFile, err)
 	}
-    })
+	urls, err := TaskURLs(entries)
+	if err != nil {
+		return fmt.Errorf("%s: %v", cronFile, err)
+	}
+	http.Handle("/scheduled", tasks)
+	for _, url := range urls {
+		http.Handle(url, tasks)
+	}
+	for _, e := range entries {
+		if !tasks.Has(e.Name) {
+			log.Printf("Task %s of %s is not registered\n", e.Name, cronFile)
+		}
+	}
+
+	http.Handle("/tasks", NewTaskDashboard(entries, tasks, history))
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	switch config.Get("SCHEDULER") {
+	case "off":
+	case "local":
+		go NewScheduler(entries, FireInProcess(tasks)).Run(ctx)
+	case "http":
+		go NewScheduler(entries, FireHTTP("http://127.0.0.1:"+port)).Run(ctx)
+	}
+
+	// Cancelling base interrupts the requests still running.
+	base, interrupt := context.WithCancel(context.Background())
+	defer interrupt()
+	server := &http.Server{
+		Addr:        ":" + port,
+		BaseContext: func(net.Listener) context.Context { return base },
+	}
+	failed := make(chan error, 1)
+	go func() {
+		log.Printf("Listening on port %s\n", port)
+		failed <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-failed:
+		return err
+	case <-ctx.Done():
+	}
+
+	timeout := config.Duration("SHUTDOWN_TIMEOUT")
+	log.Printf("Shutting down: waiting up to %v for the messages being processed\n", timeout)
+	drainCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if unfinished := worker.Drain(drainCtx); len(unfinished) > 0 {
+		// They were not recorded as processed, so sqsd delivers them again.
+		log.Printf("%d messages did not finish and will be retried: %s\n", len(unfinished), strings.Join(unfinished, ", "))
+		interrupt()
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Closing the remaining connections: %v\n", err)
+		server.Close()
+	}
+	log.Printf("Shut down\n")
+	return nil
+}
+
+// logMessage handles "log" messages by writing their payload to the log:
+//
+//	{"type": "log", "payload": {"text": "hello"}}
+func logMessage(ctx context.Context, msg Message) error {
+	log.Printf("Received message %s: %s\n", msg.ID, msg.Payload)
+	return nil
+}
 
-    log.Printf("Listening on port %s\n\n", port)
-    http.ListenAndServe(":"+port, nil)
+// runTask1 is the "task1" periodic task of cron.yaml.
+func runTask1(ctx context.Context, scheduledAt time.Time) error {
+	log.Printf("Running task1 scheduled at %s\n", scheduledAt.Format(time.RFC3339))
+	return nil
 }
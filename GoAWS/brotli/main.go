@@ -0,0 +1,63 @@
+// Command brotli writes the Brotli encoding of every file of the GoAWS site
+// that is served compressed, named after its ETag, for the application to
+// embed. It builds the site with a copy of the application's static.go, which
+// go generate puts next to it:
+//
+//	go generate static.go
+//
+// run in GoAWS. The files of the output directory are replaced.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/andybalholm/brotli"
+)
+
+func main() {
+	if len(os.Args) != 3 {
+		fmt.Fprintln(os.Stderr, "usage: brotli <site directory> <output directory>")
+		os.Exit(2)
+	}
+	if err := generate(os.Args[1], os.Args[2]); err != nil {
+		fmt.Fprintln(os.Stderr, "brotli:", err)
+		os.Exit(1)
+	}
+}
+
+func generate(siteDir, outDir string) error {
+	site, err := NewStaticSite(os.DirFS(siteDir), nil)
+	if err != nil {
+		return err
+	}
+
+	old, _ := filepath.Glob(filepath.Join(outDir, "*.br"))
+	for _, f := range old {
+		if err := os.Remove(f); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return err
+	}
+
+	// A file and its hashed path share a body, and so an encoding.
+	for _, a := range site.assets {
+		if a.gzip == nil {
+			continue
+		}
+		var buf bytes.Buffer
+		w := brotli.NewWriterLevel(&buf, brotli.BestCompression)
+		w.Write(a.body)
+		if err := w.Close(); err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(outDir, brotliName(a)), buf.Bytes(), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
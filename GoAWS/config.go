@@ -0,0 +1,351 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ebEnvFile is where Elastic Beanstalk writes the environment properties on
+// Amazon Linux 2 and later platforms.
+const ebEnvFile = "/opt/elasticbeanstalk/deployment/env"
+
+type settingKind int
+
+const (
+	kindString settingKind = iota
+	kindInt
+	kindDuration
+	kindBool
+)
+
+type setting struct {
+	name  string
+	def   string
+	usage string
+	kind  settingKind
+	// choices lists the allowed values, if limited.
+	choices []string
+	// required settings cannot be empty.
+	required bool
+	// positive numbers and durations cannot be 0.
+	positive bool
+	// secret settings are redacted on /admin/config.
+	secret bool
+}
+
+// settings are all the application's settings, named like their environment
+// variables.
+var settings = []setting{
+	{name: "PORT", def: "5000", usage: "port to listen on", kind: kindInt, required: true, positive: true},
+	{name: "PROCESSED_FILE", def: "processed-messages.log", usage: "file keeping the IDs of the messages already processed", required: true},
+	{name: "DEAD_LETTER_FILE", def: "dead-letters.json", usage: "file keeping the messages that failed, shown on /admin/dlq", required: true},
+	{name: "QUARANTINE_FILE", def: "quarantine.json", usage: "file keeping the invalid messages, shown on /admin/quarantine", required: true},
+	{name: "SCHEMA_DIR", def: "schemas", usage: "directory of the payload schemas, one <type>.json per message type"},
+	{name: "TASK_HISTORY_FILE", def: "task-history.log", usage: "file recording the task runs shown on /tasks", required: true},
+	{name: "CRON_FILE", def: "cron.yaml", usage: "file listing the periodic tasks"},
+	{name: "SCHEDULER", def: "off", usage: `where the SQS daemon does not run the periodic tasks: "local" calls them directly, "http" POSTs them to their url`, choices: []string{"off", "local", "http"}},
+
+	{name: "WORKER_CONCURRENCY", def: "10", usage: "messages processed at once", kind: kindInt, positive: true},
+	{name: "WORKER_QUEUE", def: "20", usage: "messages that may wait for a worker", kind: kindInt},
+	{name: "WORKER_QUEUE_WAIT", def: "5s", usage: "how long a message waits for a worker before sqsd is told to retry later", kind: kindDuration, positive: true},
+	{name: "MESSAGE_TIMEOUT", def: "1m", usage: "how long a message may take to process", kind: kindDuration, positive: true},
+	{name: "SHUTDOWN_TIMEOUT", def: "20s", usage: "how long the messages being processed may take to finish on shutdown", kind: kindDuration, positive: true},
+
+	{name: "LOG_FILE", def: defaultLogFile, usage: `log file, or "-" for stderr`, required: true},
+	{name: "LOG_FORMAT", def: "text", usage: "log format", choices: []string{"text", "json"}},
+	{name: "LOG_MAX_SIZE", def: "10", usage: "size in MB at which the log file is rotated, 0 for none", kind: kindInt},
+	{name: "LOG_MAX_AGE", def: "24h", usage: "age at which the log file is rotated, 0 for none", kind: kindDuration},
+	{name: "LOG_MAX_BACKUPS", def: "7", usage: "rotated log files kept, 0 for all", kind: kindInt},
+	{name: "LOG_COMPRESS", def: "true", usage: "gzip the rotated log files", kind: kindBool},
+
+	{name: "ADMIN_TOKEN", usage: "token opening the /admin pages, which are disabled without one", secret: true},
+}
+
+// Config holds the effective settings. Each comes from the first of these
+// that sets it:
+//
+//   - a command-line flag, the lower-case name with dashes (-log-file);
+//   - the environment;
+//   - the Elastic Beanstalk environment properties file;
+//   - the JSON config file, -config or CONFIG_FILE, by default config.json
+//     if there is one;
+//   - the default.
+//
+// Any setting NAME can also be read from a file given as NAME_FILE, which is
+// how secrets are best passed.
+type Config struct {
+	values  map[string]string
+	sources map[string]string
+}
+
+// LoadConfig loads and checks the settings, parsing args as flags.
+func LoadConfig(args []string) (*Config, error) {
+	c := &Config{values: map[string]string{}, sources: map[string]string{}}
+	for _, s := range settings {
+		c.values[s.name], c.sources[s.name] = s.def, "default"
+	}
+
+	flags := flag.NewFlagSet("application", flag.ContinueOnError)
+	configFile := flags.String("config", "", "JSON config file")
+	for _, s := range settings {
+		flags.String(flagName(s.name), "", s.usage)
+		flags.String(flagName(s.name)+"-file", "", "file holding the "+flagName(s.name))
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	// The config file.
+	path, explicit := *configFile, true
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+	if path == "" {
+		path, explicit = "config.json", false
+	}
+	values, err := readConfigFile(path)
+	if os.IsNotExist(err) && !explicit {
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := c.apply(values, "file "+path); err != nil {
+		return nil, err
+	}
+
+	// The Elastic Beanstalk environment properties.
+	values, err = readEnvFile(ebEnvFile)
+	if os.IsPermission(err) {
+		// The file is only readable by root, as it is on some platform
+		// versions; the properties are in the environment anyway.
+		log.Printf("Cannot read %s, ignoring it: %v\n", ebEnvFile, err)
+		err = nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err := c.apply(values, "Elastic Beanstalk"); err != nil {
+		return nil, err
+	}
+
+	// The environment.
+	values = map[string]string{}
+	for _, s := range settings {
+		for _, name := range []string{s.name, s.name + "_FILE"} {
+			if v, ok := os.LookupEnv(name); ok {
+				values[name] = v
+			}
+		}
+	}
+	if err := c.apply(values, "environment"); err != nil {
+		return nil, err
+	}
+
+	// The flags.
+	values = map[string]string{}
+	flags.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			switch f.Name {
+			case flagName(s.name):
+				values[s.name] = f.Value.String()
+			case flagName(s.name) + "-file":
+				values[s.name+"_FILE"] = f.Value.String()
+			}
+		}
+	})
+	if err := c.apply(values, "flag"); err != nil {
+		return nil, err
+	}
+
+	return c, c.check()
+}
+
+func flagName(name string) string {
+	return strings.ReplaceAll(strings.ToLower(name), "_", "-")
+}
+
+// apply sets the settings in values, reading those given as NAME_FILE.
+func (c *Config) apply(values map[string]string, source string) error {
+	for _, s := range settings {
+		if path, ok := values[s.name+"_FILE"]; ok {
+			buf, err := os.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("%s_FILE (%s): %v", s.name, source, err)
+			}
+			c.values[s.name] = strings.TrimRight(string(buf), "\r\n")
+			c.sources[s.name] = source + ", file " + path
+		} else if v, ok := values[s.name]; ok {
+			c.values[s.name], c.sources[s.name] = v, source
+		}
+	}
+	return nil
+}
+
+// check validates every setting.
+func (c *Config) check() error {
+	var problems []string
+	for _, s := range settings {
+		v := c.values[s.name]
+		if v == "" {
+			if s.required {
+				problems = append(problems, s.name+" is required")
+			}
+			continue
+		}
+
+		var err error
+		switch s.kind {
+		case kindInt:
+			var n int
+			if n, err = strconv.Atoi(v); err == nil && (n < 0 || n == 0 && s.positive) {
+				err = fmt.Errorf("out of range")
+			}
+		case kindDuration:
+			var d time.Duration
+			if d, err = time.ParseDuration(v); err == nil && (d < 0 || d == 0 && s.positive) {
+				err = fmt.Errorf("out of range")
+			}
+		case kindBool:
+			_, err = strconv.ParseBool(v)
+		}
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %q is not a valid value (from %s)", s.name, c.display(s), c.sources[s.name]))
+		} else if s.choices != nil && !slices.Contains(s.choices, v) {
+			problems = append(problems, fmt.Sprintf("%s must be one of %s, not %q (from %s)", s.name, strings.Join(s.choices, ", "), c.display(s), c.sources[s.name]))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration:\n\t%s", strings.Join(problems, "\n\t"))
+	}
+	return nil
+}
+
+// Get returns the value of a setting.
+func (c *Config) Get(name string) string {
+	if _, ok := c.values[name]; !ok {
+		panic("unknown setting " + name)
+	}
+	return c.values[name]
+}
+
+func (c *Config) Int(name string) int {
+	n, _ := strconv.Atoi(c.Get(name))
+	return n
+}
+
+func (c *Config) Duration(name string) time.Duration {
+	d, _ := time.ParseDuration(c.Get(name))
+	return d
+}
+
+func (c *Config) Bool(name string) bool {
+	b, _ := strconv.ParseBool(c.Get(name))
+	return b
+}
+
+// display returns a setting's value, redacted if it is a secret.
+func (c *Config) display(s setting) string {
+	if s.secret && c.values[s.name] != "" {
+		return "[redacted]"
+	}
+	return c.values[s.name]
+}
+
+// ConfigValue is a setting as shown on /admin/config.
+type ConfigValue struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+// Effective returns the settings, with the secrets redacted.
+func (c *Config) Effective() []ConfigValue {
+	values := make([]ConfigValue, len(settings))
+	for i, s := range settings {
+		values[i] = ConfigValue{Name: s.name, Value: c.display(s), Source: c.sources[s.name]}
+	}
+	return values
+}
+
+// ServeHTTP serves the effective settings on /admin/config.
+func (c *Config) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(c.Effective())
+}
+
+// readConfigFile reads a JSON object of settings. Values may be strings,
+// numbers or booleans.
+func readConfigFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var raw map[string]any
+	if err := json.NewDecoder(f).Decode(&raw); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	values := map[string]string{}
+	for name, v := range raw {
+		if !knownSetting(name) {
+			return nil, fmt.Errorf("%s: unknown setting %s", path, name)
+		}
+		switch v := v.(type) {
+		case string:
+			values[name] = v
+		case float64, bool:
+			values[name] = fmt.Sprint(v)
+		default:
+			return nil, fmt.Errorf("%s: %s must be a string, a number or a boolean", path, name)
+		}
+	}
+	return values, nil
+}
+
+// knownSetting tells whether name is a setting, or NAME_FILE of one.
+func knownSetting(name string) bool {
+	for _, s := range settings {
+		if name == s.name || name == s.name+"_FILE" {
+			return true
+		}
+	}
+	return false
+}
+
+// readEnvFile reads NAME=value lines, keeping the known settings.
+func readEnvFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		name, v, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if ok && knownSetting(name) {
+			values[name] = unquote(v)
+		}
+	}
+	return values, scanner.Err()
+}
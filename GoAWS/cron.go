@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math/bits"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CronEntry is a periodic task of cron.yaml.
+type CronEntry struct {
+	Name     string
+	URL      string
+	Schedule *Schedule
+}
+
+// ReadCronFile parses a cron.yaml file:
+//
+//	version: 1
+//	cron:
+//	  - name: "task1"
+//	    url: "/scheduled"
+//	    schedule: "* * * * *"
+//
+// Only this subset of YAML is understood.
+func ReadCronFile(path string) ([]CronEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var (
+		entries []CronEntry
+		fields  map[string]string
+		line    int
+	)
+	// flush checks and appends the entry being read.
+	flush := func() error {
+		if fields == nil {
+			return nil
+		}
+		e := CronEntry{Name: fields["name"], URL: fields["url"]}
+		if e.Name == "" || e.URL == "" || fields["schedule"] == "" {
+			return fmt.Errorf("%s: task %q needs a name, url and schedule", path, e.Name)
+		}
+		if !strings.HasPrefix(e.URL, "/") {
+			return fmt.Errorf("%s: task %s: url must be a path starting with /", path, e.Name)
+		}
+		if e.Schedule, err = ParseSchedule(fields["schedule"]); err != nil {
+			return fmt.Errorf("%s: task %s: %v", path, e.Name, err)
+		}
+		entries = append(entries, e)
+		fields = nil
+		return nil
+	}
+
+	inCron := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line++
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		indented := text[0] == ' ' || text[0] == '\t'
+		text = strings.TrimSpace(text)
+
+		if !indented {
+			key, _, _ := strings.Cut(text, ":")
+			inCron = strings.TrimSpace(key) == "cron"
+			continue
+		}
+		if !inCron {
+			continue
+		}
+
+		if strings.HasPrefix(text, "-") {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			fields = map[string]string{}
+			text = strings.TrimSpace(strings.TrimPrefix(text, "-"))
+		}
+		key, value, ok := strings.Cut(text, ":")
+		if !ok || fields == nil {
+			return nil, fmt.Errorf("%s:%d: cannot parse %q", path, line, text)
+		}
+		fields[strings.TrimSpace(key)] = unquote(strings.TrimSpace(value))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// appRoutes are the paths the application serves itself, with what is under
+// them; no task url may take one.
+var appRoutes = []string{"/tasks", "/admin"}
+
+// TaskURLs returns the distinct urls of entries to serve the tasks at, leaving
+// out / and /scheduled, which are served anyway. Several tasks may share a
+// url, since sqsd names the task in a header. A url of one of appRoutes is an
+// error.
+func TaskURLs(entries []CronEntry) ([]string, error) {
+	var urls []string
+	seen := map[string]bool{"/": true, "/scheduled": true}
+	for _, e := range entries {
+		for _, r := range appRoutes {
+			if e.URL == r || strings.HasPrefix(e.URL, r+"/") {
+				return nil, fmt.Errorf("task %s: url %s is taken by the application's %s pages", e.Name, e.URL, r)
+			}
+		}
+		if !seen[e.URL] {
+			seen[e.URL] = true
+			urls = append(urls, e.URL)
+		}
+	}
+	return urls, nil
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// Schedule is a standard 5-field cron expression: minute, hour, day of month,
+// month and day of week, each a *, a number or name, a range, a step or a
+// comma-separated list of those. Like in cron, when both days are restricted
+// a time matches either of them. Schedules run in UTC, as on Elastic
+// Beanstalk.
+type Schedule struct {
+	spec string
+
+	// Bitmasks of the matching values.
+	minute, hour, dom, month, dow uint64
+
+	// The day fields not starting with "*".
+	domRestricted, dowRestricted bool
+}
+
+var (
+	monthNames = map[string]int{"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6, "jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12}
+	dayNames   = map[string]int{"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6}
+
+	scheduleMacros = map[string]string{
+		"@yearly":   "0 0 1 1 *",
+		"@annually": "0 0 1 1 *",
+		"@monthly":  "0 0 1 * *",
+		"@weekly":   "0 0 * * 0",
+		"@daily":    "0 0 * * *",
+		"@midnight": "0 0 * * *",
+		"@hourly":   "0 * * * *",
+	}
+)
+
+// ParseSchedule parses a cron expression or one of the @hourly, @daily,
+// @weekly, @monthly and @yearly macros.
+func ParseSchedule(spec string) (*Schedule, error) {
+	expr := spec
+	if macro, ok := scheduleMacros[spec]; ok {
+		expr = macro
+	}
+
+	f := strings.Fields(expr)
+	if len(f) != 5 {
+		return nil, fmt.Errorf("schedule %q: want 5 fields, got %d", spec, len(f))
+	}
+
+	s := &Schedule{spec: spec}
+	var err error
+	if s.minute, err = parseField(f[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("schedule %q: minute: %v", spec, err)
+	}
+	if s.hour, err = parseField(f[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("schedule %q: hour: %v", spec, err)
+	}
+	if s.dom, err = parseField(f[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("schedule %q: day of month: %v", spec, err)
+	}
+	if s.month, err = parseField(f[3], 1, 12, monthNames); err != nil {
+		return nil, fmt.Errorf("schedule %q: month: %v", spec, err)
+	}
+	// Day of week 7 is Sunday too.
+	if s.dow, err = parseField(f[4], 0, 7, dayNames); err != nil {
+		return nil, fmt.Errorf("schedule %q: day of week: %v", spec, err)
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.domRestricted, s.dowRestricted = f[2][0] != '*', f[4][0] != '*'
+	return s, nil
+}
+
+// parseField returns the set of values matched by a field as a bitmask.
+func parseField(field string, min, max int, names map[string]int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, stepText, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepText)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step %q", stepText)
+			}
+			step = n
+		}
+
+		lo, hi := min, max
+		if rng != "*" {
+			first, last, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = fieldValue(first, min, max, names); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if isRange {
+				if hi, err = fieldValue(last, min, max, names); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				hi = max
+			}
+			if hi < lo {
+				return 0, fmt.Errorf("invalid range %q", rng)
+			}
+		}
+
+		for v := lo; v <= hi; v += step {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
+
+func fieldValue(s string, min, max int, names map[string]int) (int, error) {
+	if v, ok := names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < min || v > max {
+		return 0, fmt.Errorf("%q is not between %d and %d", s, min, max)
+	}
+	return v, nil
+}
+
+func (s *Schedule) String() string {
+	return s.spec
+}
+
+// Next returns the first time after t matching the schedule, or the zero time
+// if there is none in the next five years (e.g. "0 0 31 2 *").
+func (s *Schedule) Next(t time.Time) time.Time {
+	t = t.UTC().Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = t.Truncate(time.Hour).Add(time.Hour)
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			// Jump to the next matching minute of this hour, if any.
+			rest := s.minute >> uint(t.Minute())
+			if rest == 0 {
+				t = t.Truncate(time.Hour).Add(time.Hour)
+			} else {
+				t = t.Add(time.Duration(bits.TrailingZeros64(rest)) * time.Minute)
+			}
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (s *Schedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domRestricted && s.dowRestricted {
+		return dom || dow
+	}
+	return dom && dow
+}
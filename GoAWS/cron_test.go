@@ -0,0 +1,213 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTaskURLs(t *testing.T) {
+	entries := func(urls ...string) []CronEntry {
+		var es []CronEntry
+		for i, u := range urls {
+			es = append(es, CronEntry{Name: "task" + string(rune('1'+i)), URL: u})
+		}
+		return es
+	}
+
+	tests := []struct {
+		urls []string
+		want []string
+		err  string
+	}{
+		{[]string{"/scheduled", "/"}, nil, ""},
+		{[]string{"/cron", "/cron", "/nightly"}, []string{"/cron", "/nightly"}, ""},
+		{[]string{"/cron", "/tasks"}, nil, "task2: url /tasks is taken"},
+		{[]string{"/admin/run"}, nil, "task1: url /admin/run is taken"},
+		{[]string{"/admin"}, nil, "taken by the application's /admin pages"},
+		{[]string{"/tasksfoo", "/administration"}, []string{"/tasksfoo", "/administration"}, ""},
+	}
+	for _, tt := range tests {
+		got, err := TaskURLs(entries(tt.urls...))
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%q: error %v, want %q", tt.urls, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: TaskURLs = %q, %v, want %q", tt.urls, got, err, tt.want)
+		}
+	}
+}
+
+// bitsOf returns the bitmask of values.
+func bitsOf(values ...int) uint64 {
+	var set uint64
+	for _, v := range values {
+		set |= 1 << v
+	}
+	return set
+}
+
+func TestParseField(t *testing.T) {
+	tests := []struct {
+		field    string
+		min, max int
+		names    map[string]int
+		want     uint64
+	}{
+		{"*", 0, 6, nil, bitsOf(0, 1, 2, 3, 4, 5, 6)},
+		{"5", 0, 59, nil, bitsOf(5)},
+		{"1,3,5", 0, 59, nil, bitsOf(1, 3, 5)},
+		{"2-4", 0, 59, nil, bitsOf(2, 3, 4)},
+		{"*/15", 0, 59, nil, bitsOf(0, 15, 30, 45)},
+		{"10-20/5", 0, 59, nil, bitsOf(10, 15, 20)},
+		{"50/3", 0, 59, nil, bitsOf(50, 53, 56, 59)},
+		{"1-3,10", 1, 12, nil, bitsOf(1, 2, 3, 10)},
+		{"jan,MAR", 1, 12, monthNames, bitsOf(1, 3)},
+		{"jun-aug", 1, 12, monthNames, bitsOf(6, 7, 8)},
+		{"mon-fri", 0, 7, dayNames, bitsOf(1, 2, 3, 4, 5)},
+	}
+	for _, tt := range tests {
+		got, err := parseField(tt.field, tt.min, tt.max, tt.names)
+		if err != nil || got != tt.want {
+			t.Errorf("parseField(%q) = %b, %v, want %b", tt.field, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseScheduleErrors(t *testing.T) {
+	tests := []struct {
+		spec, err string
+	}{
+		{"* * * *", "want 5 fields, got 4"},
+		{"* * * * * *", "want 5 fields, got 6"},
+		{"@often", "want 5 fields, got 1"},
+		{"60 * * * *", `minute: "60" is not between 0 and 59`},
+		{"* 24 * * *", "hour:"},
+		{"* * 0 * *", "day of month:"},
+		{"* * * 13 *", "month:"},
+		{"* * * * 8", "day of week:"},
+		{"* * * foo *", `"foo" is not between 1 and 12`},
+		{"*/0 * * * *", `invalid step "0"`},
+		{"*/x * * * *", `invalid step "x"`},
+		{"5-1 * * * *", `invalid range "5-1"`},
+		{"1-x * * * *", `"x" is not between`},
+	}
+	for _, tt := range tests {
+		_, err := ParseSchedule(tt.spec)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("ParseSchedule(%q) error = %v, want %q", tt.spec, err, tt.err)
+		}
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	at := func(s string) time.Time {
+		t.Helper()
+		v, err := time.Parse("2006-01-02 15:04:05", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	tests := []struct {
+		spec, from, want string
+	}{
+		{"* * * * *", "2024-03-10 12:00:00", "2024-03-10 12:01:00"},
+		{"* * * * *", "2024-03-10 12:00:59", "2024-03-10 12:01:00"},
+		{"*/15 * * * *", "2024-03-10 12:16:00", "2024-03-10 12:30:00"},
+		{"*/15 * * * *", "2024-03-10 12:50:00", "2024-03-10 13:00:00"},
+		{"30 9-17/4 * * *", "2024-03-10 13:30:00", "2024-03-10 17:30:00"},
+		{"0 0 * * *", "2024-03-10 12:00:00", "2024-03-11 00:00:00"},
+		// Across the end of a month, of a leap February and of a year.
+		{"0 0 1 * *", "2024-01-31 23:59:00", "2024-02-01 00:00:00"},
+		{"0 12 29 feb *", "2023-03-01 00:00:00", "2024-02-29 12:00:00"},
+		{"0 0 31 * *", "2024-04-01 00:00:00", "2024-05-31 00:00:00"},
+		{"@yearly", "2024-12-31 23:59:00", "2025-01-01 00:00:00"},
+		{"59 23 31 dec *", "2024-12-31 23:59:00", "2025-12-31 23:59:00"},
+		// Names, and 7 as Sunday; 2024-03-10 is a Sunday.
+		{"0 8 * * mon-fri", "2024-03-08 09:00:00", "2024-03-11 08:00:00"},
+		{"0 8 * * 7", "2024-03-08 09:00:00", "2024-03-10 08:00:00"},
+		{"0 8 * * 0", "2024-03-08 09:00:00", "2024-03-10 08:00:00"},
+		{"0 8 * * sun", "2024-03-08 09:00:00", "2024-03-10 08:00:00"},
+		{"@weekly", "2024-03-10 00:00:00", "2024-03-17 00:00:00"},
+		// Both days restricted: either matches. The 13th is a Wednesday.
+		{"0 0 13 * fri", "2024-03-09 00:00:00", "2024-03-13 00:00:00"},
+		{"0 0 13 * fri", "2024-03-13 00:00:00", "2024-03-15 00:00:00"},
+		// Only one restricted: both must match.
+		{"0 0 13 * *", "2024-03-13 00:00:00", "2024-04-13 00:00:00"},
+		{"0 0 * 6 fri", "2024-03-09 00:00:00", "2024-06-07 00:00:00"},
+		// Never matches.
+		{"0 0 31 2 *", "2024-01-01 00:00:00", ""},
+		{"0 0 30 feb *", "2024-01-01 00:00:00", ""},
+	}
+	for _, tt := range tests {
+		s, err := ParseSchedule(tt.spec)
+		if err != nil {
+			t.Errorf("ParseSchedule(%q): %v", tt.spec, err)
+			continue
+		}
+		got := s.Next(at(tt.from))
+		if tt.want == "" {
+			if !got.IsZero() {
+				t.Errorf("%q.Next(%s) = %s, want never", tt.spec, tt.from, got)
+			}
+			continue
+		}
+		if want := at(tt.want); !got.Equal(want) {
+			t.Errorf("%q.Next(%s) = %s, want %s", tt.spec, tt.from, got, want)
+		}
+	}
+}
+
+func TestReadCronFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cron.yaml")
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(`version: 1
+cron:
+  # Comments and quotes are fine.
+  - name: "task1"
+    url: "/scheduled"
+    schedule: "*/5 * * * *"
+  - name: 'nightly'
+    url: /cron
+    schedule: "@daily"  # at midnight
+`)
+	entries, err := ReadCronFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("%d entries, want 2", len(entries))
+	}
+	if e := entries[0]; e.Name != "task1" || e.URL != "/scheduled" || e.Schedule.String() != "*/5 * * * *" {
+		t.Errorf("entry 0 = %+v", e)
+	}
+	if e := entries[1]; e.Name != "nightly" || e.URL != "/cron" || e.Schedule.String() != "@daily" {
+		t.Errorf("entry 1 = %+v", e)
+	}
+
+	for content, want := range map[string]string{
+		"cron:\n  - name: a\n    url: /a\n":                             `task "a" needs a name, url and schedule`,
+		"cron:\n  - name: a\n    url: a\n    schedule: \"* * * * *\"\n": "url must be a path",
+		"cron:\n  - name: a\n    url: /a\n    schedule: \"* *\"\n":      "task a: schedule",
+		"cron:\n  name: a\n": "cannot parse",
+	} {
+		write(content)
+		if _, err := ReadCronFile(path); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: error %v, want %q", content, err, want)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	// missedWindow is how far back the dashboard looks for missed runs.
+	missedWindow = 24 * time.Hour
+	// missedGrace is how late a run may start before it counts as missed.
+	missedGrace = 5 * time.Minute
+	// maxMissed bounds the missed runs listed per task.
+	maxMissed = 50
+	// recentRuns is how many runs the dashboard lists.
+	recentRuns = 50
+)
+
+// TaskStatus sums up a task of cron.yaml.
+type TaskStatus struct {
+	Name       string   `json:"name"`
+	URL        string   `json:"url"`
+	Schedule   string   `json:"schedule"`
+	Registered bool     `json:"registered"`
+	LastRun    *TaskRun `json:"last_run,omitempty"`
+	// NextRun is zero if the schedule never matches.
+	NextRun time.Time `json:"next_run"`
+	// Missed lists the most recent runs that were due in the last
+	// missedWindow but never happened; MissedCount counts all of them.
+	Missed      []time.Time `json:"missed"`
+	MissedCount int         `json:"missed_count"`
+}
+
+// TaskDashboard serves /tasks, the status of the periodic tasks, as HTML or,
+// for clients asking for it, JSON.
+type TaskDashboard struct {
+	entries  []CronEntry
+	registry *TaskRegistry
+	history  *TaskHistory
+}
+
+func NewTaskDashboard(entries []CronEntry, registry *TaskRegistry, history *TaskHistory) *TaskDashboard {
+	return &TaskDashboard{entries: entries, registry: registry, history: history}
+}
+
+// Status returns the status of every task of cron.yaml at now.
+func (d *TaskDashboard) Status(now time.Time) []TaskStatus {
+	now = now.UTC()
+	from := now.Add(-missedWindow)
+	if since := d.history.Since(); since.After(from) {
+		from = since
+	}
+
+	statuses := make([]TaskStatus, len(d.entries))
+	for i, e := range d.entries {
+		s := TaskStatus{
+			Name:       e.Name,
+			URL:        e.URL,
+			Schedule:   e.Schedule.String(),
+			Registered: d.registry.Has(e.Name),
+			NextRun:    e.Schedule.Next(now),
+			Missed:     []time.Time{},
+		}
+
+		runs := d.history.Runs(e.Name)
+		ran := map[time.Time]bool{}
+		for j := range runs {
+			ran[runs[j].ScheduledAt.UTC().Truncate(time.Minute)] = true
+		}
+		if len(runs) > 0 {
+			s.LastRun = &runs[0]
+		}
+
+		// Runs from the first due one after from up to missedGrace ago,
+		// most recent first.
+		var missed []time.Time
+		for due := e.Schedule.Next(from.Add(-time.Minute)); !due.IsZero() && due.Before(now.Add(-missedGrace)); due = e.Schedule.Next(due) {
+			if !ran[due] {
+				missed = append(missed, due)
+			}
+		}
+		s.MissedCount = len(missed)
+		for j := len(missed) - 1; j >= 0 && len(s.Missed) < maxMissed; j-- {
+			s.Missed = append(s.Missed, missed[j])
+		}
+		statuses[i] = s
+	}
+	return statuses
+}
+
+func (d *TaskDashboard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	runs := d.history.Runs("")
+	if len(runs) > recentRuns {
+		runs = runs[:recentRuns]
+	}
+	data := struct {
+		Now   time.Time    `json:"now"`
+		Tasks []TaskStatus `json:"tasks"`
+		Runs  []TaskRun    `json:"runs"`
+	}{time.Now().UTC(), d.Status(time.Now()), runs}
+	if data.Runs == nil {
+		data.Runs = []TaskRun{}
+	}
+
+	if wantsJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(data)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := dashboardTemplate.Execute(w, data); err != nil {
+		log.Printf("Cannot render the task dashboard: %v\n", err)
+	}
+}
+
+var dashboardTemplate = template.Must(template.New("tasks").Funcs(template.FuncMap{
+	"when": func(t time.Time) string {
+		if t.IsZero() {
+			return "never"
+		}
+		return t.Format("2006-01-02 15:04:05 MST")
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Periodic tasks</title>
+<style>
+body { color: #222222; background-color: #e0ebf5; font-family: Arial, sans-serif; font-size: 14px; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { text-align: left; padding: 0.3em 1em; border-bottom: 1px solid #b8cde0; }
+.ok { color: #2e7d32; }
+.failed, .missed { color: #c62828; }
+.skipped { color: #ef6c00; }
+</style>
+</head>
+<body>
+<h1>Periodic tasks</h1>
+<p>As of {{when .Now}}.</p>
+<table>
+<tr><th>Task</th><th>URL</th><th>Schedule</th><th>Last run</th><th>Next run</th><th>Missed in the last day</th></tr>
+{{range .Tasks}}
+<tr>
+<td>{{.Name}}{{if not .Registered}} (not registered){{end}}</td>
+<td>{{.URL}}</td>
+<td><code>{{.Schedule}}</code></td>
+<td>{{with .LastRun}}{{when .ScheduledAt}}: <span class="{{.Outcome}}">{{.Outcome}}</span> in {{.Duration}}{{else}}never{{end}}</td>
+<td>{{when .NextRun}}</td>
+<td>{{if .MissedCount}}<span class="missed">{{.MissedCount}}</span>{{range .Missed}}<br>{{when .}}{{end}}{{else}}none{{end}}</td>
+</tr>
+{{else}}
+<tr><td colspan="6">cron.yaml has no tasks.</td></tr>
+{{end}}
+</table>
+<h2>Recent runs</h2>
+<table>
+<tr><th>Task</th><th>Scheduled at</th><th>Started</th><th>Duration</th><th>Outcome</th></tr>
+{{range .Runs}}
+<tr>
+<td>{{.Task}}</td>
+<td>{{when .ScheduledAt}}</td>
+<td>{{when .Started}}</td>
+<td>{{.Duration}}</td>
+<td class="{{.Outcome}}">{{.Outcome}}{{with .Error}}: {{.}}{{end}}</td>
+</tr>
+{{else}}
+<tr><td colspan="5">No runs yet.</td></tr>
+{{end}}
+</table>
+</body>
+</html>
+`))
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func mustSchedule(t *testing.T, spec string) *Schedule {
+	t.Helper()
+
+	s, err := ParseSchedule(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestStatus(t *testing.T) {
+	// A history reaching back two days, whatever the time; now is fixed
+	// relative to it.
+	base := time.Now().UTC().Truncate(time.Hour).Add(-48 * time.Hour)
+	now := base.Add(30*time.Hour + 3*time.Minute)
+	ran := func(scheduledAt time.Time) TaskRun {
+		return TaskRun{Task: "hourly", ScheduledAt: scheduledAt, Started: scheduledAt.Add(30 * time.Second), Outcome: RunOK}
+	}
+	history := openHistoryWith(t,
+		ran(base),
+		ran(base.Add(28*time.Hour)),
+		// Scheduled at a few seconds past the minute, as sqsd may send.
+		ran(base.Add(29*time.Hour+20*time.Second)),
+	)
+	registry := NewTaskRegistry(history)
+	registry.Register("hourly", 0, func(context.Context, time.Time) error { return nil })
+
+	d := NewTaskDashboard([]CronEntry{
+		{Name: "hourly", URL: "/scheduled", Schedule: mustSchedule(t, "0 * * * *")},
+		{Name: "minutely", URL: "/scheduled", Schedule: mustSchedule(t, "* * * * *")},
+	}, registry, history)
+	statuses := d.Status(now)
+	if len(statuses) != 2 {
+		t.Fatalf("got %d statuses, want 2", len(statuses))
+	}
+
+	hourly := statuses[0]
+	if !hourly.Registered || hourly.Schedule != "0 * * * *" {
+		t.Errorf("hourly = %+v", hourly)
+	}
+	if hourly.LastRun == nil || !hourly.LastRun.ScheduledAt.Equal(base.Add(29*time.Hour+20*time.Second)) {
+		t.Errorf("last run = %+v, want the one of %v", hourly.LastRun, base.Add(29*time.Hour))
+	}
+	if want := base.Add(31 * time.Hour); !hourly.NextRun.Equal(want) {
+		t.Errorf("next run = %v, want %v", hourly.NextRun, want)
+	}
+	// The window starts 24 hours before now, the run due at 30h is within
+	// the grace period, and those of 28h and 29h happened: 7h to 27h were
+	// missed.
+	if hourly.MissedCount != 21 || len(hourly.Missed) != 21 {
+		t.Fatalf("missed %d (%d listed), want 21", hourly.MissedCount, len(hourly.Missed))
+	}
+	if first, last := hourly.Missed[0], hourly.Missed[20]; !first.Equal(base.Add(27*time.Hour)) || !last.Equal(base.Add(7*time.Hour)) {
+		t.Errorf("missed from %v back to %v, want from 27h back to 7h", first, last)
+	}
+
+	minutely := statuses[1]
+	if minutely.Registered || minutely.LastRun != nil {
+		t.Errorf("minutely = %+v, want it unregistered and never run", minutely)
+	}
+	// Every minute from 6h03 to 29h57.
+	if minutely.MissedCount != 1435 {
+		t.Errorf("minutely missed %d, want 1435", minutely.MissedCount)
+	}
+	if len(minutely.Missed) != maxMissed {
+		t.Fatalf("minutely lists %d missed, want %d", len(minutely.Missed), maxMissed)
+	}
+	if want := base.Add(29*time.Hour + 57*time.Minute); !minutely.Missed[0].Equal(want) {
+		t.Errorf("minutely missed %v last, want %v", minutely.Missed[0], want)
+	}
+}
+
+func TestStatusSince(t *testing.T) {
+	// The history only began at half past an hour, about two hours ago.
+	since := time.Now().UTC().Truncate(time.Hour).Add(-90 * time.Minute)
+	history := openHistoryWith(t, TaskRun{Task: "other", ScheduledAt: since, Started: since, Outcome: RunOK})
+	d := NewTaskDashboard([]CronEntry{
+		{Name: "hourly", URL: "/scheduled", Schedule: mustSchedule(t, "0 * * * *")},
+	}, NewTaskRegistry(history), history)
+
+	// Runs due before the history began are not missed, only those at
+	// 30 minutes, 1h30 and 2h30 after it.
+	s := d.Status(since.Add(3 * time.Hour))[0]
+	if s.MissedCount != 3 || !s.Missed[0].Equal(since.Add(150*time.Minute)) || !s.Missed[2].Equal(since.Add(30*time.Minute)) {
+		t.Errorf("missed %d: %v, want the 3 due since %v", s.MissedCount, s.Missed, since)
+	}
+	if s.LastRun != nil {
+		t.Errorf("last run = %+v, want none", s.LastRun)
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxDeadLetters bounds the failed messages kept; the oldest go first.
+const maxDeadLetters = 1000
+
+// DeadLetter is a message whose last delivery failed.
+type DeadLetter struct {
+	ID            string    `json:"id"`
+	Type          string    `json:"type,omitempty"`
+	Body          string    `json:"body"`
+	Error         string    `json:"error"`
+	ReceiveCount  string    `json:"receive_count,omitempty"`
+	FirstReceived string    `json:"first_received,omitempty"`
+	Failures      int       `json:"failures"`
+	FirstFailed   time.Time `json:"first_failed"`
+	LastFailed    time.Time `json:"last_failed"`
+}
+
+// DeadLetters keeps the messages that failed, with why, until they are
+// processed by a retry or a replay, or discarded. Once SQS gives up on a
+// message it moves to the queue's dead-letter queue, out of the
+// application's sight; this is where it can still be inspected. Every change
+// is appended to a file of JSON lines, which is compacted when opened and
+// once it holds many more records than letters.
+type DeadLetters struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	records int
+	letters map[string]*DeadLetter
+}
+
+// deadLetterRecord is a line of the file: a letter failing again or for the
+// first time, or the ID of a letter removed.
+type deadLetterRecord struct {
+	Letter  *DeadLetter `json:"letter,omitempty"`
+	Removed string      `json:"removed,omitempty"`
+}
+
+// minCompact is how many records the file may hold, however few letters
+// there are, before it is compacted.
+const minCompact = 100
+
+// OpenDeadLetters loads the letters recorded in path. A file saved by an
+// earlier version, holding a JSON array of the letters, is read too.
+func OpenDeadLetters(path string) (*DeadLetters, error) {
+	d := &DeadLetters{path: path, letters: map[string]*DeadLetter{}}
+
+	buf, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if trimmed := bytes.TrimSpace(buf); len(trimmed) > 0 && trimmed[0] == '[' {
+		var letters []*DeadLetter
+		if err := json.Unmarshal(trimmed, &letters); err != nil {
+			return nil, err
+		}
+		for _, l := range letters {
+			d.letters[l.ID] = l
+		}
+	} else {
+		for _, line := range bytes.Split(buf, []byte("\n")) {
+			var rec deadLetterRecord
+			if json.Unmarshal(line, &rec) != nil {
+				// An empty line, or one cut short by a crash.
+				continue
+			}
+			if rec.Letter != nil {
+				d.letters[rec.Letter.ID] = rec.Letter
+			} else {
+				delete(d.letters, rec.Removed)
+			}
+		}
+	}
+
+	if err := d.compact(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Fail records a failed delivery of msg.
+func (d *DeadLetters) Fail(msg Message, body []byte, cause error) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now().UTC()
+	l, ok := d.letters[msg.ID]
+	if !ok {
+		l = &DeadLetter{ID: msg.ID, FirstFailed: now}
+		d.letters[msg.ID] = l
+	}
+	l.Body, l.Error, l.LastFailed = string(body), cause.Error(), now
+	l.ReceiveCount, l.FirstReceived = msg.ReceiveCount, msg.FirstReceived
+	l.Failures++
+
+	var env Envelope
+	if json.Unmarshal(body, &env) == nil {
+		l.Type = env.Type
+	}
+
+	if err := d.append(deadLetterRecord{Letter: l}); err != nil {
+		return err
+	}
+	if len(d.letters) > maxDeadLetters {
+		oldest := l
+		for _, other := range d.letters {
+			if other.LastFailed.Before(oldest.LastFailed) {
+				oldest = other
+			}
+		}
+		delete(d.letters, oldest.ID)
+		return d.append(deadLetterRecord{Removed: oldest.ID})
+	}
+	return nil
+}
+
+// Remove drops the letter of a message, if there is one.
+func (d *DeadLetters) Remove(id string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.letters[id]; !ok {
+		return nil
+	}
+	delete(d.letters, id)
+	return d.append(deadLetterRecord{Removed: id})
+}
+
+func (d *DeadLetters) Get(id string) (DeadLetter, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	l, ok := d.letters[id]
+	if !ok {
+		return DeadLetter{}, false
+	}
+	return *l, true
+}
+
+// List returns the letters, the latest failure first.
+func (d *DeadLetters) List() []DeadLetter {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	letters := make([]DeadLetter, 0, len(d.letters))
+	for _, l := range d.letters {
+		letters = append(letters, *l)
+	}
+	sort.Slice(letters, func(i, j int) bool { return letters[i].LastFailed.After(letters[j].LastFailed) })
+	return letters
+}
+
+func (d *DeadLetters) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.file.Close()
+}
+
+// append writes rec to the file, compacting it first if most of its
+// records are stale.
+func (d *DeadLetters) append(rec deadLetterRecord) error {
+	if d.records > minCompact && d.records > 2*len(d.letters) {
+		if err := d.compact(); err != nil {
+			return err
+		}
+	}
+	buf, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := d.file.Write(append(buf, '\n')); err != nil {
+		return err
+	}
+	d.records++
+	return nil
+}
+
+// compact rewrites the file with a record per letter, to a temporary file
+// renamed over it so a crash never leaves it half written, and opens it
+// for appending.
+func (d *DeadLetters) compact() error {
+	tmp := d.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, l := range d.letters {
+		enc.Encode(deadLetterRecord{Letter: l})
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, d.path); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(d.path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if d.file != nil {
+		d.file.Close()
+	}
+	d.file, d.records = file, len(d.letters)
+	return nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func openTestDeadLetters(t *testing.T, path string) *DeadLetters {
+	t.Helper()
+
+	d, err := OpenDeadLetters(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	return d
+}
+
+func countLines(t *testing.T, path string) int {
+	t.Helper()
+
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Count(string(buf), "\n")
+}
+
+func TestDeadLettersReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead.json")
+	d := openTestDeadLetters(t, path)
+	for _, id := range []string{"m1", "m2", "m1"} {
+		if err := d.Fail(Message{ID: id, ReceiveCount: "1"}, []byte(`{"type":"log"}`), errors.New("boom")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.Remove("m2"); err != nil {
+		t.Fatal(err)
+	}
+	// Every change is appended.
+	if n := countLines(t, path); n != 4 {
+		t.Errorf("file holds %d records, want 4", n)
+	}
+	d.Close()
+
+	d = openTestDeadLetters(t, path)
+	letters := d.List()
+	if len(letters) != 1 || letters[0].ID != "m1" || letters[0].Failures != 2 || letters[0].Type != "log" {
+		t.Fatalf("letters = %+v, want m1 failed twice", letters)
+	}
+	// Opening compacts the file.
+	if n := countLines(t, path); n != 1 {
+		t.Errorf("reopened file holds %d records, want 1", n)
+	}
+}
+
+func TestDeadLettersCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead.json")
+	d := openTestDeadLetters(t, path)
+	for i := 0; i < 3*minCompact; i++ {
+		if err := d.Fail(Message{ID: "m1"}, []byte("body"), errors.New("boom")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := countLines(t, path); n > minCompact+1 {
+		t.Errorf("file holds %d records of one letter, want it compacted", n)
+	}
+	if l, ok := d.Get("m1"); !ok || l.Failures != 3*minCompact {
+		t.Errorf("m1 = %+v, want %d failures", l, 3*minCompact)
+	}
+}
+
+func TestDeadLettersTornLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead.json")
+	content := `{"letter":{"id":"m1","body":"a","error":"boom","failures":1}}` + "\n" + `{"letter":{"id":"m2","bo`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d := openTestDeadLetters(t, path)
+	if letters := d.List(); len(letters) != 1 || letters[0].ID != "m1" {
+		t.Errorf("letters = %+v, want m1 only", letters)
+	}
+}
+
+func TestDeadLettersLegacyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead.json")
+	content := `[
+  {"id": "m1", "body": "a", "error": "boom", "failures": 1},
+  {"id": "m2", "body": "b", "error": "boom", "failures": 3}
+]`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d := openTestDeadLetters(t, path)
+	if l, ok := d.Get("m2"); !ok || l.Failures != 3 || len(d.List()) != 2 {
+		t.Errorf("m2 = %+v, letters = %+v", l, d.List())
+	}
+	// The file is rewritten as JSON lines.
+	if n := countLines(t, path); n != 2 {
+		t.Errorf("file holds %d lines, want a record per letter", n)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// historyTTL is how long task runs are remembered.
+const historyTTL = 7 * 24 * time.Hour
+
+// Outcomes of a task run.
+const (
+	RunOK      = "ok"
+	RunFailed  = "failed"
+	RunSkipped = "skipped"
+)
+
+// TaskRun is one invocation of a periodic task.
+type TaskRun struct {
+	Task        string        `json:"task"`
+	ScheduledAt time.Time     `json:"scheduled_at"`
+	Started     time.Time     `json:"started"`
+	Duration    time.Duration `json:"duration"`
+	Outcome     string        `json:"outcome"`
+	Error       string        `json:"error,omitempty"`
+}
+
+// TaskHistory records the task runs, appending them to a file of JSON lines
+// so they survive restarts.
+type TaskHistory struct {
+	mu    sync.Mutex
+	file  *os.File
+	runs  []TaskRun
+	since time.Time
+}
+
+// OpenTaskHistory loads the runs recorded in path, dropping the expired ones.
+func OpenTaskHistory(path string) (*TaskHistory, error) {
+	h := &TaskHistory{since: time.Now().UTC()}
+
+	if f, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var run TaskRun
+			if json.Unmarshal(scanner.Bytes(), &run) == nil && time.Since(run.Started) < historyTTL {
+				h.runs = append(h.runs, run)
+			}
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	sort.Slice(h.runs, func(i, j int) bool { return h.runs[i].Started.Before(h.runs[j].Started) })
+	if len(h.runs) > 0 && h.runs[0].Started.Before(h.since) {
+		h.since = h.runs[0].Started
+	}
+
+	// Rewrite the file without the expired runs.
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, run := range h.runs {
+		enc.Encode(run)
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return nil, err
+	}
+
+	if h.file, err = os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// Record adds a finished run.
+func (h *TaskHistory) Record(run TaskRun) error {
+	buf, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.runs = append(h.runs, run)
+	// Drop the expired runs once they are a good part of the slice.
+	if expired := sort.Search(len(h.runs), func(i int) bool {
+		return time.Since(h.runs[i].Started) < historyTTL
+	}); expired > len(h.runs)/2 {
+		h.runs = append([]TaskRun(nil), h.runs[expired:]...)
+	}
+	_, err = h.file.Write(append(buf, '\n'))
+	return err
+}
+
+// Runs returns the runs of task, or of every task if task is "", most recent
+// first.
+func (h *TaskHistory) Runs(task string) []TaskRun {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var runs []TaskRun
+	for i := len(h.runs) - 1; i >= 0; i-- {
+		if task == "" || h.runs[i].Task == task {
+			runs = append(runs, h.runs[i])
+		}
+	}
+	return runs
+}
+
+// Since returns when the history began: the oldest run kept, or when it was
+// opened if that is earlier.
+func (h *TaskHistory) Since() time.Time {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.since
+}
+
+func (h *TaskHistory) Close() error {
+	return h.file.Close()
+}
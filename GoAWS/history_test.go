@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// openHistoryWith returns a history opened from a file holding runs.
+func openHistoryWith(t *testing.T, runs ...TaskRun) *TaskHistory {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "history.log")
+	var lines []string
+	for _, run := range runs {
+		buf, err := json.Marshal(run)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, string(buf)+"\n")
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "")), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := OpenTaskHistory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { h.Close() })
+	return h
+}
+
+func TestHistoryTTL(t *testing.T) {
+	now := time.Now().UTC()
+	expired := TaskRun{Task: "job", ScheduledAt: now.Add(-8 * 24 * time.Hour), Started: now.Add(-8 * 24 * time.Hour), Outcome: RunOK}
+	kept := TaskRun{Task: "job", ScheduledAt: now.Add(-24 * time.Hour), Started: now.Add(-24 * time.Hour), Outcome: RunOK}
+	h := openHistoryWith(t, kept, expired)
+
+	runs := h.Runs("job")
+	if len(runs) != 1 || !runs[0].Started.Equal(kept.Started) {
+		t.Fatalf("runs = %+v, want only the one of yesterday", runs)
+	}
+	if !h.Since().Equal(kept.Started) {
+		t.Errorf("since = %v, want the oldest run kept, %v", h.Since(), kept.Started)
+	}
+	buf, err := os.ReadFile(h.file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(buf), "\n"); n != 1 {
+		t.Errorf("file holds %d runs, want the expired one dropped", n)
+	}
+
+	if err := h.Record(TaskRun{Task: "job", ScheduledAt: now, Started: now, Outcome: RunOK}); err != nil {
+		t.Fatal(err)
+	}
+	if runs := h.Runs(""); len(runs) != 2 || !runs[0].Started.Equal(now) || !runs[1].Started.Equal(kept.Started) {
+		t.Errorf("runs = %+v, want today's and yesterday's", runs)
+	}
+}
+
+func TestHistorySinceOpened(t *testing.T) {
+	before := time.Now().UTC()
+	h := newTestHistory(t)
+	if since := h.Since(); since.Before(before) || since.After(time.Now()) {
+		t.Errorf("since = %v, want when the empty history was opened", since)
+	}
+}
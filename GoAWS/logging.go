@@ -0,0 +1,268 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultLogFile is where Elastic Beanstalk collects the application's logs.
+const defaultLogFile = "/var/log/golang/golang-server.log"
+
+// LogConfig says where and how the application logs.
+type LogConfig struct {
+	// File is the log file, or "-" for stderr.
+	File string
+	// Format is "text" or "json".
+	Format string
+
+	// MaxSize and MaxAge rotate the file once it grows past MaxSize bytes or
+	// was written to for longer than MaxAge; 0 disables either.
+	MaxSize int64
+	MaxAge  time.Duration
+	// MaxBackups is how many rotated files are kept; 0 keeps them all.
+	MaxBackups int
+	// Compress gzips the rotated files.
+	Compress bool
+}
+
+// LogConfigFrom takes the LOG_ settings of c.
+func LogConfigFrom(c *Config) LogConfig {
+	return LogConfig{
+		File:       c.Get("LOG_FILE"),
+		Format:     c.Get("LOG_FORMAT"),
+		MaxSize:    int64(c.Int("LOG_MAX_SIZE")) << 20,
+		MaxAge:     c.Duration("LOG_MAX_AGE"),
+		MaxBackups: c.Int("LOG_MAX_BACKUPS"),
+		Compress:   c.Bool("LOG_COMPRESS"),
+	}
+}
+
+// SetupLogging sends the standard logger, and slog, to the configured
+// destination. When the file cannot be opened it logs to stderr instead of
+// losing the logs. The returned function closes the file.
+func SetupLogging(c LogConfig) func() {
+	var (
+		out      io.Writer = os.Stderr
+		closeLog           = func() {}
+	)
+	if c.File != "-" {
+		f, err := OpenRotatingFile(c.File, c.MaxSize, c.MaxAge, c.MaxBackups, c.Compress)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot open the log file, logging to stderr: %v\n", err)
+		} else {
+			out, closeLog = f, func() { f.Close() }
+		}
+	}
+
+	if c.Format == "json" {
+		// The standard logger writes through the default slog handler, so
+		// every log.Printf becomes a JSON record.
+		slog.SetDefault(slog.New(slog.NewJSONHandler(out, nil)))
+	} else {
+		log.SetOutput(out)
+	}
+	return closeLog
+}
+
+// RotatingFile is a log file that is renamed to path.<time> and started
+// again when it gets too big or too old. Rotated files are gzipped and
+// pruned in the background, one rotation after the other, so that pruning
+// never sees a file half compressed. Once the file cannot be written to,
+// lines go to stderr.
+type RotatingFile struct {
+	path       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+	compress   bool
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+	wg     sync.WaitGroup
+	// bg is held by the background work of a rotation.
+	bg sync.Mutex
+}
+
+// OpenRotatingFile opens path for appending, creating it and its directory if
+// needed.
+func OpenRotatingFile(path string, maxSize int64, maxAge time.Duration, maxBackups int, compress bool) (*RotatingFile, error) {
+	rf := &RotatingFile{path: path, maxSize: maxSize, maxAge: maxAge, maxBackups: maxBackups, compress: compress}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *RotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.file, rf.size, rf.opened = f, info.Size(), time.Now()
+	return nil
+}
+
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.file == nil {
+		return os.Stderr.Write(p)
+	}
+	tooBig := rf.maxSize > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.maxSize
+	tooOld := rf.maxAge > 0 && rf.size > 0 && time.Since(rf.opened) > rf.maxAge
+	if tooBig || tooOld {
+		if err := rf.rotate(); err != nil {
+			// Keep writing to the current file rather than drop the line.
+			fmt.Fprintf(os.Stderr, "Cannot rotate %s: %v\n", rf.path, err)
+		}
+	}
+	if rf.file == nil {
+		// The file could not be opened again after rotating it.
+		return os.Stderr.Write(p)
+	}
+
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+func (rf *RotatingFile) rotate() error {
+	// Two rotations within a millisecond must not overwrite each other; the
+	// later one takes the next millisecond, so the names still sort by time.
+	at := time.Now().UTC()
+	backup := rf.path + "." + at.Format("20060102T150405.000")
+	for exists(backup) || exists(backup+".gz") {
+		at = at.Add(time.Millisecond)
+		backup = rf.path + "." + at.Format("20060102T150405.000")
+	}
+	if err := os.Rename(rf.path, backup); err != nil {
+		return err
+	}
+	rf.file.Close()
+	if err := rf.open(); err != nil {
+		rf.file = nil
+		return err
+	}
+
+	rf.wg.Add(1)
+	go func() {
+		defer rf.wg.Done()
+		rf.bg.Lock()
+		defer rf.bg.Unlock()
+		if rf.compress {
+			rf.compressBackups()
+		}
+		rf.prune()
+	}()
+	return nil
+}
+
+// compressBackups gzips the rotated files not compressed yet: this rotation's
+// and those of rotations whose background work has not run yet, which then
+// finds nothing to do.
+func (rf *RotatingFile) compressBackups() {
+	files, _ := filepath.Glob(rf.path + ".2*")
+	for _, f := range files {
+		if strings.HasSuffix(f, ".gz") {
+			continue
+		}
+		if err := gzipFile(f); err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot compress %s: %v\n", f, err)
+		}
+	}
+}
+
+// prune removes the oldest rotated files beyond maxBackups. A backup left
+// both plain and gzipped, as by a compression that was interrupted, counts
+// once and both go together.
+func (rf *RotatingFile) prune() {
+	if rf.maxBackups <= 0 {
+		return
+	}
+	files, _ := filepath.Glob(rf.path + ".2*")
+	backups := map[string][]string{}
+	for _, f := range files {
+		base := strings.TrimSuffix(f, ".gz")
+		backups[base] = append(backups[base], f)
+	}
+	names := make([]string, 0, len(backups))
+	for base := range backups {
+		names = append(names, base)
+	}
+	// The time in the names sorts them oldest first.
+	sort.Strings(names)
+	for ; len(names) > rf.maxBackups; names = names[1:] {
+		for _, f := range backups[names[0]] {
+			os.Remove(f)
+		}
+	}
+}
+
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+// Close closes the file once the rotated files are compressed.
+func (rf *RotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	rf.wg.Wait()
+	if rf.file == nil {
+		return nil
+	}
+	err := rf.file.Close()
+	rf.file = nil
+	return err
+}
+
+// gzipFile replaces path with path.gz.
+func gzipFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	if _, err := io.Copy(zw, in); err != nil {
+		out.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		out.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// backups returns the rotated files of path, oldest first.
+func backups(t *testing.T, path string) []string {
+	t.Helper()
+
+	files, err := filepath.Glob(path + ".2*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	return files
+}
+
+func readLog(t *testing.T, path string) string {
+	t.Helper()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = zr
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// writeLines writes one 10 byte line per letter of s.
+func writeLines(t *testing.T, rf *RotatingFile, s string) {
+	t.Helper()
+
+	for _, c := range s {
+		if _, err := rf.Write([]byte(strings.Repeat(string(c), 9) + "\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRotateSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "app.log")
+	rf, err := OpenRotatingFile(path, 25, 0, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeLines(t, rf, "abcde")
+	rf.Close()
+
+	// Two lines fit in 25 bytes, the third starts a new file.
+	files := backups(t, path)
+	if len(files) != 2 {
+		t.Fatalf("backups = %v, want 2", files)
+	}
+	for i, want := range []string{"aaaaaaaaa\nbbbbbbbbb\n", "ccccccccc\nddddddddd\n"} {
+		if got := readLog(t, files[i]); got != want {
+			t.Errorf("%s = %q, want %q", files[i], got, want)
+		}
+	}
+	if got, want := readLog(t, path), "eeeeeeeee\n"; got != want {
+		t.Errorf("log = %q, want %q", got, want)
+	}
+}
+
+func TestRotateAge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	rf, err := OpenRotatingFile(path, 0, 10*time.Millisecond, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeLines(t, rf, "ab")
+	time.Sleep(20 * time.Millisecond)
+	writeLines(t, rf, "c")
+	rf.Close()
+
+	files := backups(t, path)
+	if len(files) != 1 || readLog(t, files[0]) != "aaaaaaaaa\nbbbbbbbbb\n" {
+		t.Fatalf("backups = %v, want the first two lines", files)
+	}
+	if got, want := readLog(t, path), "ccccccccc\n"; got != want {
+		t.Errorf("log = %q, want %q", got, want)
+	}
+}
+
+func TestRotateCompressPrune(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	rf, err := OpenRotatingFile(path, 10, 0, 2, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeLines(t, rf, "abcdef")
+	rf.Close()
+
+	// Five rotations, of which the last two are kept, compressed.
+	files := backups(t, path)
+	if len(files) != 2 {
+		t.Fatalf("backups = %v, want 2", files)
+	}
+	for i, want := range []string{"ddddddddd\n", "eeeeeeeee\n"} {
+		if !strings.HasSuffix(files[i], ".gz") {
+			t.Errorf("%s is not compressed", files[i])
+			continue
+		}
+		if got := readLog(t, files[i]); got != want {
+			t.Errorf("%s = %q, want %q", files[i], got, want)
+		}
+	}
+}
+
+func TestPruneCountsBackupOnce(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	// The oldest backup was left both plain and compressed.
+	names := []string{
+		".20240101T000000.000", ".20240101T000000.000.gz",
+		".20240102T000000.000.gz",
+		".20240103T000000.000", ".20240103T000000.000.gz",
+		".20240104T000000.000.gz",
+	}
+	for _, name := range names {
+		if err := os.WriteFile(path+name, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rf := &RotatingFile{path: path, maxBackups: 2}
+	rf.prune()
+
+	var got []string
+	for _, f := range backups(t, path) {
+		got = append(got, strings.TrimPrefix(f, path))
+	}
+	want := []string{".20240103T000000.000", ".20240103T000000.000.gz", ".20240104T000000.000.gz"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("after prune = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	errBusy         = errors.New("all workers are busy")
+	errOverloaded   = errors.New("too many messages are waiting for a worker")
+	errWaitCanceled = errors.New("stopped waiting for a worker")
+)
+
+// Worker metrics, served on /admin/metrics with the rest of expvar.
+var (
+	workerMetrics   = expvar.NewMap("worker")
+	workerInFlight  = new(expvar.Int)
+	workerWaiting   = new(expvar.Int)
+	workerWaitMax   = new(expvar.Int)
+	workerWaitTotal = new(expvar.Int)
+	workerWaitCount = new(expvar.Int)
+	workerRejected  = new(expvar.Map).Init()
+	workerOutcomes  = new(expvar.Map).Init()
+	workerWaitMaxMu sync.Mutex
+)
+
+func init() {
+	workerMetrics.Set("in_flight", workerInFlight)
+	workerMetrics.Set("waiting", workerWaiting)
+	workerMetrics.Set("queue_wait_max_ms", workerWaitMax)
+	workerMetrics.Set("queue_wait_total_ms", workerWaitTotal)
+	workerMetrics.Set("queue_wait_count", workerWaitCount)
+	workerMetrics.Set("rejected", workerRejected)
+	workerMetrics.Set("outcomes", workerOutcomes)
+}
+
+// Pool bounds how many messages are processed at once. A message waits at
+// most wait for a free worker, and only maxWaiting messages may wait at all,
+// so that when the application is saturated sqsd is told so quickly and
+// retries later, instead of piling up requests.
+type Pool struct {
+	slots      chan struct{}
+	maxWaiting int
+	wait       time.Duration
+
+	mu      sync.Mutex
+	waiting int
+}
+
+func NewPool(size, maxWaiting int, wait time.Duration) *Pool {
+	return &Pool{slots: make(chan struct{}, size), maxWaiting: maxWaiting, wait: wait}
+}
+
+// Acquire takes a worker, which must be given back with Release. It returns
+// errOverloaded if too many messages are waiting already, errBusy if none
+// was free in time and errWaitCanceled, wrapping ctx's error, if ctx is done
+// first.
+func (p *Pool) Acquire(ctx context.Context) error {
+	select {
+	case p.slots <- struct{}{}:
+		workerInFlight.Add(1)
+		recordWait(0)
+		return nil
+	default:
+	}
+
+	p.mu.Lock()
+	if p.waiting >= p.maxWaiting {
+		p.mu.Unlock()
+		workerRejected.Add("overloaded", 1)
+		return errOverloaded
+	}
+	p.waiting++
+	p.mu.Unlock()
+	workerWaiting.Add(1)
+
+	defer func() {
+		p.mu.Lock()
+		p.waiting--
+		p.mu.Unlock()
+		workerWaiting.Add(-1)
+	}()
+
+	start := time.Now()
+	timer := time.NewTimer(p.wait)
+	defer timer.Stop()
+	select {
+	case p.slots <- struct{}{}:
+		workerInFlight.Add(1)
+		recordWait(time.Since(start))
+		return nil
+	case <-timer.C:
+		workerRejected.Add("busy", 1)
+		return errBusy
+	case <-ctx.Done():
+		workerRejected.Add("canceled", 1)
+		return fmt.Errorf("%w: %w", errWaitCanceled, ctx.Err())
+	}
+}
+
+func (p *Pool) Release() {
+	<-p.slots
+	workerInFlight.Add(-1)
+}
+
+func recordWait(d time.Duration) {
+	ms := d.Milliseconds()
+	workerWaitTotal.Add(ms)
+	workerWaitCount.Add(1)
+
+	workerWaitMaxMu.Lock()
+	if ms > workerWaitMax.Value() {
+		workerWaitMax.Set(ms)
+	}
+	workerWaitMaxMu.Unlock()
+}
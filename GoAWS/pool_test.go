@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitForWaiting waits until n messages are waiting for a worker of p.
+func waitForWaiting(t *testing.T, p *Pool, n int) {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		p.mu.Lock()
+		waiting := p.waiting
+		p.mu.Unlock()
+		if waiting == n {
+			return
+		}
+	}
+	t.Fatalf("%d messages never waited for a worker", n)
+}
+
+func TestPoolBusy(t *testing.T) {
+	p := NewPool(1, 1, 50*time.Millisecond)
+	if err := p.Acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	if err := p.Acquire(context.Background()); !errors.Is(err, errBusy) {
+		t.Fatalf("Acquire at capacity = %v, want errBusy", err)
+	}
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Errorf("Acquire gave up after %v, before its wait of 50ms", d)
+	}
+
+	p.Release()
+	if err := p.Acquire(context.Background()); err != nil {
+		t.Errorf("Acquire after Release = %v", err)
+	}
+	p.Release()
+}
+
+func TestPoolOverloaded(t *testing.T) {
+	p := NewPool(1, 1, time.Minute)
+	if err := p.Acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	waiter := make(chan error, 1)
+	go func() { waiter <- p.Acquire(context.Background()) }()
+	waitForWaiting(t, p, 1)
+
+	start := time.Now()
+	if err := p.Acquire(context.Background()); !errors.Is(err, errOverloaded) {
+		t.Fatalf("Acquire with the queue full = %v, want errOverloaded", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Acquire with the queue full took %v, want an answer at once", d)
+	}
+
+	// The waiter gets the worker given back.
+	p.Release()
+	if err := <-waiter; err != nil {
+		t.Fatalf("waiting Acquire = %v", err)
+	}
+	waitForWaiting(t, p, 0)
+	p.Release()
+}
+
+func TestPoolCanceled(t *testing.T) {
+	p := NewPool(1, 1, time.Minute)
+	if err := p.Acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Release()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	waiter := make(chan error, 1)
+	go func() { waiter <- p.Acquire(ctx) }()
+	waitForWaiting(t, p, 1)
+	cancel()
+	if err := <-waiter; !errors.Is(err, errWaitCanceled) || !errors.Is(err, context.Canceled) {
+		t.Errorf("Acquire canceled = %v, want errWaitCanceled wrapping context.Canceled", err)
+	}
+	waitForWaiting(t, p, 0)
+}
+
+func TestWorkerSaturated(t *testing.T) {
+	pool := NewPool(1, 0, 10*time.Millisecond)
+	wk := newTestWorker(t, func(ctx context.Context, msg Message) error { return nil }, pool)
+	if err := pool.Acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Release()
+
+	w := deliver(wk, "m1", `{"type": "log", "payload": {"text": "hello"}}`)
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Errorf("overloaded: status %d, Retry-After %q, want 503 and a Retry-After", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	pool.maxWaiting = 1
+	w = deliver(wk, "m2", `{"type": "log", "payload": {"text": "hello"}}`)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("busy: status %d, Retry-After %q, want 429 and a Retry-After", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	// Neither was processed, so a redelivery is not skipped.
+	if _, ok := wk.dead.Get("m1"); ok {
+		t.Error("rejected message recorded as a dead letter")
+	}
+	if done, err := wk.processed.Begin("m2"); err != nil || done {
+		t.Errorf("rejected message: Begin = %v, %v, want it still to be processed", done, err)
+	}
+}
+
+func TestWorkerGaveUpWaiting(t *testing.T) {
+	pool := NewPool(1, 1, time.Minute)
+	wk := newTestWorker(t, func(ctx context.Context, msg Message) error { return nil }, pool)
+	if err := pool.Acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Release()
+
+	// sqsd gives up on the request while it waits for a worker.
+	ctx, cancel := context.WithCancel(context.Background())
+	r := httptest.NewRequest("POST", "/", strings.NewReader(`{"type": "log", "payload": {"text": "hello"}}`)).WithContext(ctx)
+	r.Header.Set("X-Aws-Sqsd-Msgid", "m1")
+	w := httptest.NewRecorder()
+	served := make(chan struct{})
+	go func() {
+		wk.ServeHTTP(w, r)
+		close(served)
+	}()
+	waitForWaiting(t, pool, 1)
+	cancel()
+	<-served
+
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Errorf("status %d, Retry-After %q, want 503 and a Retry-After", w.Code, w.Header().Get("Retry-After"))
+	}
+	if _, ok := wk.dead.Get("m1"); ok {
+		t.Error("message given up on recorded as a dead letter")
+	}
+	if done, err := wk.processed.Begin("m1"); err != nil || done {
+		t.Errorf("Begin = %v, %v, want the message still to be processed", done, err)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// processedTTL is how long a message ID is remembered: the longest time SQS
+// keeps a message, after which it cannot be redelivered.
+const processedTTL = 14 * 24 * time.Hour
+
+// ProcessedSet remembers which messages were processed, so that a message
+// delivered twice (SQS guarantees at-least-once delivery) is only handled
+// once. IDs are appended to a file as they complete, so they survive
+// restarts.
+type ProcessedSet struct {
+	mu       sync.Mutex
+	file     *os.File
+	done     map[string]time.Time
+	inFlight map[string]bool
+}
+
+// OpenProcessedSet loads the IDs recorded in path, dropping the expired ones.
+func OpenProcessedSet(path string) (*ProcessedSet, error) {
+	p := &ProcessedSet{done: map[string]time.Time{}, inFlight: map[string]bool{}}
+
+	if f, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			id, at, ok := strings.Cut(scanner.Text(), "\t")
+			if !ok {
+				continue
+			}
+			if t, err := time.Parse(time.RFC3339, at); err == nil && time.Since(t) < processedTTL {
+				p.done[id] = t
+			}
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	// Rewrite the file without the expired IDs.
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(f)
+	for id, t := range p.done {
+		w.WriteString(id + "\t" + t.Format(time.RFC3339) + "\n")
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return nil, err
+	}
+
+	if p.file, err = os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Begin claims a message for processing. It returns true if the message was
+// already processed, and errInFlight if another delivery is processing it.
+func (p *ProcessedSet) Begin(id string) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.done[id]; ok {
+		return true, nil
+	}
+	if p.inFlight[id] {
+		return false, errInFlight
+	}
+	p.inFlight[id] = true
+	return false, nil
+}
+
+// Finish records a claimed message as processed.
+func (p *ProcessedSet) Finish(id string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.inFlight, id)
+	now := time.Now().UTC()
+	p.done[id] = now
+	_, err := p.file.WriteString(id + "\t" + now.Format(time.RFC3339) + "\n")
+	return err
+}
+
+// Abort releases a claimed message that failed, so it can be retried.
+func (p *ProcessedSet) Abort(id string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.inFlight, id)
+}
+
+func (p *ProcessedSet) Close() error {
+	return p.file.Close()
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Scheduler fires the tasks of cron.yaml on their schedule, for running the
+// application where the SQS daemon does not do it. Runs are fired on their
+// own goroutine, so a slow task does not delay the others.
+type Scheduler struct {
+	entries []CronEntry
+	fire    func(ctx context.Context, e CronEntry, at time.Time)
+}
+
+func NewScheduler(entries []CronEntry, fire func(ctx context.Context, e CronEntry, at time.Time)) *Scheduler {
+	return &Scheduler{entries: entries, fire: fire}
+}
+
+// Run fires the tasks until ctx is done.
+func (s *Scheduler) Run(ctx context.Context) {
+	for _, e := range s.entries {
+		go s.runEntry(ctx, e)
+	}
+	<-ctx.Done()
+}
+
+func (s *Scheduler) runEntry(ctx context.Context, e CronEntry) {
+	for {
+		next := e.Schedule.Next(time.Now())
+		if next.IsZero() {
+			log.Printf("Task %s (%s) never runs\n", e.Name, e.Schedule)
+			return
+		}
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+			go s.fire(ctx, e, next)
+		}
+	}
+}
+
+// FireInProcess runs tasks straight from registry.
+func FireInProcess(registry *TaskRegistry) func(ctx context.Context, e CronEntry, at time.Time) {
+	return func(ctx context.Context, e CronEntry, at time.Time) {
+		log.Printf("Running task %s scheduled at %s\n", e.Name, at.Format(time.RFC3339))
+		if err := registry.Run(ctx, e.Name, at); err != nil {
+			log.Printf("Task %s: %v\n", e.Name, err)
+		}
+	}
+}
+
+// FireHTTP POSTs tasks to their url on baseURL, with the headers of the SQS
+// daemon.
+func FireHTTP(baseURL string) func(ctx context.Context, e CronEntry, at time.Time) {
+	client := &http.Client{}
+	return func(ctx context.Context, e CronEntry, at time.Time) {
+		req, err := http.NewRequestWithContext(ctx, "POST", strings.TrimSuffix(baseURL, "/")+e.URL, nil)
+		if err != nil {
+			log.Printf("Task %s: %v\n", e.Name, err)
+			return
+		}
+		req.Header.Set("User-Agent", "aws-sqsd/local")
+		req.Header.Set("X-Aws-Sqsd-Taskname", e.Name)
+		req.Header.Set("X-Aws-Sqsd-Scheduled-At", at.UTC().Format(time.RFC3339))
+
+		resp, err := client.Do(req)
+		if err != nil {
+			log.Printf("Task %s: %v\n", e.Name, err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			log.Printf("Task %s: %s answered %s\n", e.Name, e.URL, resp.Status)
+		}
+	}
+}
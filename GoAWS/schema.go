@@ -0,0 +1,451 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxSchemaErrors bounds the violations reported for one message.
+const maxSchemaErrors = 10
+
+// Schema is a compiled JSON Schema. It understands the keywords messages
+// need: type, enum, const, properties, required, additionalProperties,
+// items, minItems, maxItems, minLength, maxLength, pattern, minimum,
+// maximum, exclusiveMinimum, exclusiveMaximum, allOf, anyOf, oneOf and not.
+// Annotations such as title and description are ignored; other keywords,
+// like $ref, are refused rather than silently skipped.
+type Schema struct {
+	types    []string
+	enum     []any
+	constant *any
+
+	properties           map[string]*Schema
+	required             []string
+	additionalProperties *Schema
+	noAdditional         bool
+
+	items              *Schema
+	minItems, maxItems *int
+
+	minLength, maxLength *int
+	pattern              *regexp.Regexp
+
+	minimum, maximum                   *float64
+	exclusiveMinimum, exclusiveMaximum *float64
+
+	allOf, anyOf, oneOf []*Schema
+	not                 *Schema
+
+	// never is set by the schema false, which matches nothing.
+	never bool
+}
+
+var schemaAnnotations = map[string]bool{
+	"$schema": true, "$id": true, "$comment": true, "title": true,
+	"description": true, "default": true, "examples": true,
+}
+
+var schemaTypes = map[string]bool{
+	"null": true, "boolean": true, "object": true, "array": true,
+	"number": true, "string": true, "integer": true,
+}
+
+// ParseSchema compiles a JSON Schema document.
+func ParseSchema(doc []byte) (*Schema, error) {
+	var v any
+	d := json.NewDecoder(bytes.NewReader(doc))
+	d.UseNumber()
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+	return compileSchema(v, "")
+}
+
+func compileSchema(v any, at string) (*Schema, error) {
+	if b, ok := v.(bool); ok {
+		return &Schema{never: !b}, nil
+	}
+	m, ok := v.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%s: a schema must be an object or a boolean", schemaPath(at))
+	}
+
+	s := &Schema{}
+	for key, value := range m {
+		at := at + "/" + key
+		var err error
+		switch key {
+		case "type":
+			s.types, err = schemaTypeList(value, at)
+		case "enum":
+			list, ok := value.([]any)
+			if !ok {
+				return nil, fmt.Errorf("%s: want an array", at)
+			}
+			s.enum = list
+		case "const":
+			s.constant = &value
+		case "properties":
+			props, ok := value.(map[string]any)
+			if !ok {
+				return nil, fmt.Errorf("%s: want an object", at)
+			}
+			s.properties = map[string]*Schema{}
+			for name, sub := range props {
+				if s.properties[name], err = compileSchema(sub, at+"/"+name); err != nil {
+					return nil, err
+				}
+			}
+		case "required":
+			list, ok := value.([]any)
+			if !ok {
+				return nil, fmt.Errorf("%s: want an array of names", at)
+			}
+			for _, name := range list {
+				name, ok := name.(string)
+				if !ok {
+					return nil, fmt.Errorf("%s: want an array of names", at)
+				}
+				s.required = append(s.required, name)
+			}
+		case "additionalProperties":
+			if b, ok := value.(bool); ok {
+				s.noAdditional = !b
+			} else {
+				s.additionalProperties, err = compileSchema(value, at)
+			}
+		case "items":
+			s.items, err = compileSchema(value, at)
+		case "minItems":
+			s.minItems, err = schemaCount(value, at)
+		case "maxItems":
+			s.maxItems, err = schemaCount(value, at)
+		case "minLength":
+			s.minLength, err = schemaCount(value, at)
+		case "maxLength":
+			s.maxLength, err = schemaCount(value, at)
+		case "pattern":
+			expr, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s: want a string", at)
+			}
+			if s.pattern, err = regexp.Compile(expr); err != nil {
+				return nil, fmt.Errorf("%s: %v", at, err)
+			}
+		case "minimum":
+			s.minimum, err = schemaNumber(value, at)
+		case "maximum":
+			s.maximum, err = schemaNumber(value, at)
+		case "exclusiveMinimum":
+			s.exclusiveMinimum, err = schemaNumber(value, at)
+		case "exclusiveMaximum":
+			s.exclusiveMaximum, err = schemaNumber(value, at)
+		case "allOf":
+			s.allOf, err = schemaList(value, at)
+		case "anyOf":
+			s.anyOf, err = schemaList(value, at)
+		case "oneOf":
+			s.oneOf, err = schemaList(value, at)
+		case "not":
+			s.not, err = compileSchema(value, at)
+		default:
+			if !schemaAnnotations[key] {
+				return nil, fmt.Errorf("%s: unsupported keyword", at)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func schemaPath(at string) string {
+	if at == "" {
+		return "schema"
+	}
+	return at
+}
+
+func schemaTypeList(v any, at string) ([]string, error) {
+	var names []string
+	switch v := v.(type) {
+	case string:
+		names = []string{v}
+	case []any:
+		for _, name := range v {
+			name, ok := name.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s: want type names", at)
+			}
+			names = append(names, name)
+		}
+	default:
+		return nil, fmt.Errorf("%s: want a type name or an array of them", at)
+	}
+	for _, name := range names {
+		if !schemaTypes[name] {
+			return nil, fmt.Errorf("%s: unknown type %q", at, name)
+		}
+	}
+	return names, nil
+}
+
+func schemaNumber(v any, at string) (*float64, error) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return nil, fmt.Errorf("%s: want a number", at)
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", at, err)
+	}
+	return &f, nil
+}
+
+func schemaCount(v any, at string) (*int, error) {
+	f, err := schemaNumber(v, at)
+	if err != nil {
+		return nil, err
+	}
+	if *f < 0 || *f != math.Trunc(*f) {
+		return nil, fmt.Errorf("%s: want a non-negative integer", at)
+	}
+	n := int(*f)
+	return &n, nil
+}
+
+func schemaList(v any, at string) ([]*Schema, error) {
+	list, ok := v.([]any)
+	if !ok || len(list) == 0 {
+		return nil, fmt.Errorf("%s: want a non-empty array of schemas", at)
+	}
+	schemas := make([]*Schema, len(list))
+	for i, sub := range list {
+		var err error
+		if schemas[i], err = compileSchema(sub, fmt.Sprintf("%s/%d", at, i)); err != nil {
+			return nil, err
+		}
+	}
+	return schemas, nil
+}
+
+// Validate checks a JSON document against the schema. The error lists the
+// first violations, each prefixed with where in doc it is.
+func (s *Schema) Validate(doc []byte) error {
+	var v any
+	if len(bytes.TrimSpace(doc)) == 0 {
+		doc = []byte("null")
+	}
+	d := json.NewDecoder(bytes.NewReader(doc))
+	d.UseNumber()
+	if err := d.Decode(&v); err != nil {
+		return err
+	}
+
+	var errs []string
+	s.validate(v, "payload", &errs)
+	if len(errs) == 0 {
+		return nil
+	}
+	if len(errs) > maxSchemaErrors {
+		errs = append(errs[:maxSchemaErrors], fmt.Sprintf("and %d more", len(errs)-maxSchemaErrors))
+	}
+	return fmt.Errorf("%s", strings.Join(errs, "; "))
+}
+
+func (s *Schema) validate(v any, at string, errs *[]string) {
+	fail := func(format string, args ...any) {
+		*errs = append(*errs, at+": "+fmt.Sprintf(format, args...))
+	}
+
+	if s.never {
+		fail("not allowed")
+		return
+	}
+	if len(s.types) > 0 && !s.hasType(v) {
+		fail("want %s, got %s", strings.Join(s.types, " or "), jsonType(v))
+		return
+	}
+	if s.enum != nil && !containsJSON(s.enum, v) {
+		fail("not one of the allowed values")
+	}
+	if s.constant != nil && !equalJSON(*s.constant, v) {
+		fail("not the allowed value")
+	}
+
+	switch v := v.(type) {
+	case map[string]any:
+		for _, name := range s.required {
+			if _, ok := v[name]; !ok {
+				fail("missing %q", name)
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if sub, ok := s.properties[name]; ok {
+				sub.validate(v[name], at+"."+name, errs)
+			} else if s.noAdditional {
+				fail("unexpected property %q", name)
+			} else if s.additionalProperties != nil {
+				s.additionalProperties.validate(v[name], at+"."+name, errs)
+			}
+		}
+	case []any:
+		if s.minItems != nil && len(v) < *s.minItems {
+			fail("want at least %d items, got %d", *s.minItems, len(v))
+		}
+		if s.maxItems != nil && len(v) > *s.maxItems {
+			fail("want at most %d items, got %d", *s.maxItems, len(v))
+		}
+		if s.items != nil {
+			for i, item := range v {
+				s.items.validate(item, fmt.Sprintf("%s[%d]", at, i), errs)
+			}
+		}
+	case string:
+		n := utf8.RuneCountInString(v)
+		if s.minLength != nil && n < *s.minLength {
+			fail("want at least %d characters, got %d", *s.minLength, n)
+		}
+		if s.maxLength != nil && n > *s.maxLength {
+			fail("want at most %d characters, got %d", *s.maxLength, n)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			fail("does not match %s", s.pattern)
+		}
+	case json.Number:
+		f, _ := v.Float64()
+		if s.minimum != nil && f < *s.minimum {
+			fail("%v is less than %v", v, *s.minimum)
+		}
+		if s.maximum != nil && f > *s.maximum {
+			fail("%v is more than %v", v, *s.maximum)
+		}
+		if s.exclusiveMinimum != nil && f <= *s.exclusiveMinimum {
+			fail("%v is not more than %v", v, *s.exclusiveMinimum)
+		}
+		if s.exclusiveMaximum != nil && f >= *s.exclusiveMaximum {
+			fail("%v is not less than %v", v, *s.exclusiveMaximum)
+		}
+	}
+
+	for _, sub := range s.allOf {
+		sub.validate(v, at, errs)
+	}
+	if s.anyOf != nil && s.matching(s.anyOf, v) == 0 {
+		fail("matches none of anyOf")
+	}
+	if s.oneOf != nil {
+		if n := s.matching(s.oneOf, v); n != 1 {
+			fail("matches %d of oneOf, want exactly 1", n)
+		}
+	}
+	if s.not != nil && s.not.matches(v) {
+		fail("matches the schema of not")
+	}
+}
+
+func (s *Schema) matches(v any) bool {
+	var errs []string
+	s.validate(v, "", &errs)
+	return len(errs) == 0
+}
+
+func (s *Schema) matching(schemas []*Schema, v any) int {
+	n := 0
+	for _, sub := range schemas {
+		if sub.matches(v) {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *Schema) hasType(v any) bool {
+	got := jsonType(v)
+	for _, t := range s.types {
+		if t == got || t == "number" && got == "integer" {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonType names the JSON type of a value decoded with UseNumber.
+func jsonType(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case json.Number:
+		if f, err := v.Float64(); err == nil && f == math.Trunc(f) {
+			return "integer"
+		}
+		return "number"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func containsJSON(list []any, v any) bool {
+	for _, item := range list {
+		if equalJSON(item, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// equalJSON compares two decoded values, numbers by value.
+func equalJSON(a, b any) bool {
+	an, aok := a.(json.Number)
+	bn, bok := b.(json.Number)
+	if aok && bok {
+		af, _ := an.Float64()
+		bf, _ := bn.Float64()
+		return af == bf
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// LoadSchemas reads the schemas of a directory, one <type>.json file per
+// message type, and sets them on registry. A missing directory has no
+// schemas.
+func LoadSchemas(dir string, registry *Registry) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		doc, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		schema, err := ParseSchema(doc)
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		registry.SetSchema(strings.TrimSuffix(filepath.Base(path), ".json"), schema)
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		keyword string
+		schema  string
+		valid   string
+		invalid string
+		// reason is part of the error for invalid.
+		reason string
+	}{
+		{"type", `{"type": "string"}`, `"a"`, `1`, "want string, got integer"},
+		{"type list", `{"type": ["string", "null"]}`, `null`, `true`, "want string or null, got boolean"},
+		{"type integer", `{"type": "integer"}`, `2.0`, `2.5`, "want integer, got number"},
+		{"type number", `{"type": "number"}`, `2`, `"2"`, "want number, got string"},
+		{"type object", `{"type": "object"}`, `{}`, `[]`, "want object, got array"},
+		{"enum", `{"enum": ["a", 1, null]}`, `1.0`, `"b"`, "not one of the allowed values"},
+		{"const", `{"const": {"a": [1]}}`, `{"a": [1]}`, `{"a": [2]}`, "not the allowed value"},
+		{"required", `{"required": ["a", "b"]}`, `{"a": 1, "b": 2}`, `{"a": 1}`, `payload: missing "b"`},
+		{"properties", `{"properties": {"a": {"type": "string"}}}`, `{"a": "x", "b": 1}`, `{"a": 1}`, "payload.a: want string"},
+		{"additionalProperties false", `{"properties": {"a": {}}, "additionalProperties": false}`, `{"a": 1}`, `{"a": 1, "b": 2}`, `unexpected property "b"`},
+		{"additionalProperties schema", `{"additionalProperties": {"type": "integer"}}`, `{"a": 1}`, `{"a": "x"}`, "payload.a: want integer"},
+		{"items", `{"items": {"type": "integer"}}`, `[1, 2]`, `[1, "x"]`, "payload[1]: want integer"},
+		{"minItems", `{"minItems": 2}`, `[1, 2]`, `[1]`, "want at least 2 items, got 1"},
+		{"maxItems", `{"maxItems": 1}`, `[1]`, `[1, 2]`, "want at most 1 items, got 2"},
+		{"minLength", `{"minLength": 2}`, `"éé"`, `"é"`, "want at least 2 characters, got 1"},
+		{"maxLength", `{"maxLength": 2}`, `"éé"`, `"ééé"`, "want at most 2 characters, got 3"},
+		{"pattern", `{"pattern": "^[a-z]+$"}`, `"abc"`, `"abc1"`, "does not match ^[a-z]+$"},
+		{"minimum", `{"minimum": 1}`, `1`, `0.5`, "0.5 is less than 1"},
+		{"maximum", `{"maximum": 1}`, `1`, `1.5`, "1.5 is more than 1"},
+		{"exclusiveMinimum", `{"exclusiveMinimum": 1}`, `1.5`, `1`, "1 is not more than 1"},
+		{"exclusiveMaximum", `{"exclusiveMaximum": 1}`, `0.5`, `1`, "1 is not less than 1"},
+		{"allOf", `{"allOf": [{"minimum": 1}, {"maximum": 3}]}`, `2`, `4`, "4 is more than 3"},
+		{"anyOf", `{"anyOf": [{"type": "string"}, {"minimum": 10}]}`, `"a"`, `5`, "matches none of anyOf"},
+		{"oneOf", `{"oneOf": [{"type": "integer"}, {"minimum": 10}]}`, `5`, `20`, "matches 2 of oneOf, want exactly 1"},
+		{"oneOf none", `{"oneOf": [{"type": "integer"}, {"type": "string"}]}`, `"a"`, `null`, "matches 0 of oneOf"},
+		{"not", `{"not": {"type": "null"}}`, `0`, `null`, "matches the schema of not"},
+		{"true", `true`, `{"any": "thing"}`, ``, ""},
+		{"false", `false`, ``, `{}`, "not allowed"},
+		{"nested", `{"properties": {"a": {"properties": {"b": {"type": "string"}}}}}`, `{"a": {"b": "x"}}`, `{"a": {"b": 1}}`, "payload.a.b: want string"},
+		{"annotations", `{"$schema": "x", "title": "t", "description": "d", "type": "string"}`, `"a"`, `1`, "want string"},
+		{"empty payload", `{"type": "object"}`, `{}`, ``, "want object, got null"},
+	}
+	for _, tt := range tests {
+		s, err := ParseSchema([]byte(tt.schema))
+		if err != nil {
+			t.Errorf("%s: ParseSchema: %v", tt.keyword, err)
+			continue
+		}
+		if tt.valid != "" {
+			if err := s.Validate([]byte(tt.valid)); err != nil {
+				t.Errorf("%s: %s is valid, got %v", tt.keyword, tt.valid, err)
+			}
+		}
+		if tt.reason != "" {
+			err := s.Validate([]byte(tt.invalid))
+			if err == nil {
+				t.Errorf("%s: %s is invalid, got no error", tt.keyword, tt.invalid)
+			} else if !strings.Contains(err.Error(), tt.reason) {
+				t.Errorf("%s: %s: error %q does not say %q", tt.keyword, tt.invalid, err, tt.reason)
+			}
+		}
+	}
+}
+
+func TestValidateManyErrors(t *testing.T) {
+	s, err := ParseSchema([]byte(`{"items": {"type": "string"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Validate([]byte(`[` + strings.Repeat(`1, `, maxSchemaErrors+4) + `1]`))
+	if err == nil || !strings.HasSuffix(err.Error(), "; and 5 more") {
+		t.Errorf("error = %v, want the first %d violations and a count of the others", err, maxSchemaErrors)
+	}
+}
+
+func TestParseSchemaRefused(t *testing.T) {
+	tests := []struct {
+		schema string
+		reason string
+	}{
+		{`{"$ref": "#/definitions/a"}`, "/$ref: unsupported keyword"},
+		{`{"properties": {"a": {"$ref": "#"}}}`, "/properties/a/$ref: unsupported keyword"},
+		{`{"if": {}, "then": {}}`, "unsupported keyword"},
+		{`{"format": "email"}`, "/format: unsupported keyword"},
+		{`{"type": "text"}`, `unknown type "text"`},
+		{`{"type": 1}`, "want a type name"},
+		{`{"enum": "a"}`, "want an array"},
+		{`{"required": [1]}`, "want an array of names"},
+		{`{"minLength": -1}`, "want a non-negative integer"},
+		{`{"maxItems": 1.5}`, "want a non-negative integer"},
+		{`{"minimum": "1"}`, "want a number"},
+		{`{"pattern": "("}`, "/pattern:"},
+		{`{"oneOf": []}`, "want a non-empty array of schemas"},
+		{`{"not": 1}`, "a schema must be an object or a boolean"},
+		{`[]`, "schema: a schema must be an object or a boolean"},
+		{`{`, "unexpected EOF"},
+	}
+	for _, tt := range tests {
+		_, err := ParseSchema([]byte(tt.schema))
+		if err == nil {
+			t.Errorf("%s: no error, want %q", tt.schema, tt.reason)
+		} else if !strings.Contains(err.Error(), tt.reason) {
+			t.Errorf("%s: error %q does not say %q", tt.schema, err, tt.reason)
+		}
+	}
+}
+
+func TestLoadSchemas(t *testing.T) {
+	registry := NewRegistry()
+	if err := LoadSchemas("schemas", registry); err != nil {
+		t.Fatal(err)
+	}
+	s := registry.Schema("log")
+	if s == nil {
+		t.Fatal("no schema for log messages")
+	}
+	if err := s.Validate([]byte(`{"text": "hello"}`)); err != nil {
+		t.Errorf("valid log payload: %v", err)
+	}
+	if err := s.Validate([]byte(`{"text": "hello", "level": "info"}`)); err == nil {
+		t.Error("log payload with an unknown property is valid")
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "bad.json"), []byte(`{"$ref": "#"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadSchemas(dir, NewRegistry()); err == nil || !strings.Contains(err.Error(), "bad.json") {
+		t.Errorf("loading a bad schema: err = %v, want one naming the file", err)
+	}
+	if err := LoadSchemas(filepath.Join(dir, "missing"), NewRegistry()); err != nil {
+		t.Errorf("missing directory: %v", err)
+	}
+}
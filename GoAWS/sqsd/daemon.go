@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config holds the worker environment settings sqsd takes from Elastic
+// Beanstalk. The defaults are those of a new worker environment.
+type Config struct {
+	// URL is where the application listens, e.g. http://localhost:5000.
+	URL string
+	// Path is where queue messages are POSTed; periodic tasks go to the URL
+	// of their cron.yaml entry instead.
+	Path     string
+	MIMEType string
+
+	// Connections bounds the concurrent deliveries.
+	Connections int
+	// InactivityTimeout is how long a delivery may wait for the response.
+	InactivityTimeout time.Duration
+	// VisibilityTimeout hides a received message from other deliveries.
+	VisibilityTimeout time.Duration
+	// ErrorVisibilityTimeout is how long a message whose delivery failed
+	// waits before it is retried.
+	ErrorVisibilityTimeout time.Duration
+
+	// PollInterval is how often an idle daemon looks for messages.
+	PollInterval time.Duration
+}
+
+func DefaultConfig() Config {
+	return Config{
+		URL:                    "http://localhost:5000",
+		Path:                   "/",
+		MIMEType:               "application/json",
+		Connections:            50,
+		InactivityTimeout:      299 * time.Second,
+		VisibilityTimeout:      300 * time.Second,
+		ErrorVisibilityTimeout: 2 * time.Second,
+		PollInterval:           100 * time.Millisecond,
+	}
+}
+
+// Daemon delivers the messages of a queue to the application the way the
+// Elastic Beanstalk SQS daemon does: a 200 response deletes the message, any
+// other response makes it visible again after the error visibility timeout,
+// and no response leaves it invisible until the visibility timeout runs out.
+type Daemon struct {
+	config Config
+	queue  *Queue
+	client *http.Client
+}
+
+func NewDaemon(config Config, queue *Queue) *Daemon {
+	return &Daemon{
+		config: config,
+		queue:  queue,
+		client: &http.Client{Timeout: config.InactivityTimeout},
+	}
+}
+
+// Run delivers messages until ctx is done, then waits for the deliveries in
+// progress.
+func (d *Daemon) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	slots := make(chan struct{}, d.config.Connections)
+	for {
+		free := cap(slots) - len(slots)
+		msgs := d.queue.Receive(free, d.config.VisibilityTimeout)
+		for _, m := range msgs {
+			slots <- struct{}{}
+			wg.Add(1)
+			go func(m Message) {
+				defer wg.Done()
+				defer func() { <-slots }()
+				d.deliver(m)
+			}(m)
+		}
+
+		if len(msgs) > 0 && len(msgs) == free {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(d.config.PollInterval):
+		}
+	}
+}
+
+func (d *Daemon) deliver(m Message) {
+	path := d.config.Path
+	if m.Task != "" {
+		path = m.URL
+	}
+
+	req, err := http.NewRequest("POST", strings.TrimSuffix(d.config.URL, "/")+path, strings.NewReader(m.Body))
+	if err != nil {
+		d.queue.Fail(m.ID, err.Error(), d.config.ErrorVisibilityTimeout)
+		return
+	}
+	d.setHeaders(req, m)
+
+	resp, err := d.client.Do(req)
+	var timeout interface{ Timeout() bool }
+	switch {
+	case errors.As(err, &timeout) && timeout.Timeout():
+		log.Printf("%s: no response in %v, retrying after the visibility timeout", m.ID, d.config.InactivityTimeout)
+		d.queue.Timeout(m.ID, "no response")
+	case err != nil:
+		log.Printf("%s: %v", m.ID, err)
+		d.queue.Fail(m.ID, err.Error(), d.config.ErrorVisibilityTimeout)
+	case resp.StatusCode != http.StatusOK:
+		resp.Body.Close()
+		log.Printf("%s: %s (receive count %d)", m.ID, resp.Status, m.ReceiveCount)
+		d.queue.Fail(m.ID, resp.Status, d.config.ErrorVisibilityTimeout)
+	default:
+		resp.Body.Close()
+		log.Printf("%s: delivered to %s", m.ID, path)
+		d.queue.Delete(m.ID)
+	}
+}
+
+// setHeaders adds the headers sqsd sends with every message.
+func (d *Daemon) setHeaders(req *http.Request, m Message) {
+	h := req.Header
+	h.Set("User-Agent", "aws-sqsd/local")
+	h.Set("Content-Type", d.config.MIMEType)
+	h.Set("X-Aws-Sqsd-Msgid", m.ID)
+	h.Set("X-Aws-Sqsd-Queue", d.queue.Name)
+	h.Set("X-Aws-Sqsd-Receive-Count", strconv.Itoa(m.ReceiveCount))
+	h.Set("X-Aws-Sqsd-First-Received-At", m.FirstReceived.UTC().Format(time.RFC3339))
+	h.Set("X-Aws-Sqsd-Sender-Id", "local")
+
+	for name, value := range m.Attributes {
+		h.Set("X-Aws-Sqsd-Attr-"+name, value)
+	}
+
+	if m.Task != "" {
+		h.Set("X-Aws-Sqsd-Taskname", m.Task)
+		h.Set("X-Aws-Sqsd-Scheduled-At", m.ScheduledAt.UTC().Format(time.RFC3339))
+	}
+}
+
+// Task builds the message sqsd sends for a periodic task due at at.
+func Task(name, url string, at time.Time) Message {
+	return Message{Task: name, URL: url, ScheduledAt: at}
+}
@@ -0,0 +1,178 @@
+/*
+sqsd is a local stand-in for the Elastic Beanstalk SQS daemon, to run the
+GoAWS worker without deploying it.
+
+It keeps an in-memory queue and POSTs its messages to the application with the
+X-Aws-Sqsd-* headers of the real daemon, honouring the visibility timeout, the
+error visibility timeout, the inactivity timeout and the redrive policy: a
+message received -max-receive times without a 200 response moves to the
+dead-letter queue, written to -dlq when set.
+
+Messages come from -send, or from the files dropped in -dir, whose contents
+become the message bodies. The files are removed once queued. Periodic tasks
+named with -task are queued once, for their url in -cron:
+
+	(cd sqsd && go build -o ../bin/sqsd *.go)
+	bin/sqsd -dir messages -dlq dead-letters
+	bin/sqsd -send '{"type": "log", "payload": {"text": "hello"}}'
+	bin/sqsd -cron cron.yaml -task task1
+*/
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+func main() {
+	config := DefaultConfig()
+	flag.StringVar(&config.URL, "url", config.URL, "URL of the application")
+	flag.StringVar(&config.Path, "path", config.Path, "HTTP path messages are POSTed to")
+	flag.StringVar(&config.MIMEType, "mime-type", config.MIMEType, "Content-Type of the messages")
+	flag.IntVar(&config.Connections, "connections", config.Connections, "maximum concurrent deliveries")
+	flag.DurationVar(&config.InactivityTimeout, "inactivity-timeout", config.InactivityTimeout, "how long to wait for a response")
+	flag.DurationVar(&config.VisibilityTimeout, "visibility-timeout", config.VisibilityTimeout, "how long a received message stays hidden")
+	flag.DurationVar(&config.ErrorVisibilityTimeout, "error-visibility-timeout", config.ErrorVisibilityTimeout, "how long a failed message waits before a retry")
+	maxReceive := flag.Int("max-receive", 10, "receives before a message moves to the dead-letter queue (0 for never)")
+	retention := flag.Duration("retention", 4*24*time.Hour, "how long a message is kept on the queue")
+	dir := flag.String("dir", "", "directory to take message files from")
+	dlq := flag.String("dlq", "", "directory to write dead letters to")
+	send := flag.String("send", "", "body of a message to queue")
+	cron := flag.String("cron", "cron.yaml", "cron.yaml with the periodic tasks")
+	tasks := flag.String("task", "", "comma-separated periodic tasks to queue")
+	flag.Parse()
+
+	queue := NewQueue("local-worker-queue", *maxReceive, *retention)
+
+	if *send != "" {
+		queue.Send(Message{Body: *send})
+	}
+	if *tasks != "" {
+		urls, err := taskURLs(*cron)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, name := range strings.Split(*tasks, ",") {
+			url, ok := urls[name]
+			if !ok {
+				log.Fatalf("no task %q in %s", name, *cron)
+			}
+			queue.Send(Task(name, url, time.Now()))
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if *dir != "" {
+		go watchDir(ctx, *dir, queue, config.PollInterval)
+	}
+	if *dlq != "" {
+		go writeDeadLetters(ctx, *dlq, queue, config.PollInterval)
+	}
+
+	log.Printf("Delivering to %s", config.URL)
+	NewDaemon(config, queue).Run(ctx)
+}
+
+// watchDir queues the files that appear in dir and removes them.
+func watchDir(ctx context.Context, dir string, queue *Queue, interval time.Duration) {
+	for {
+		paths, _ := filepath.Glob(filepath.Join(dir, "*"))
+		for _, path := range paths {
+			if info, err := os.Stat(path); err != nil || !info.Mode().IsRegular() {
+				continue
+			}
+			body, err := os.ReadFile(path)
+			if err != nil {
+				log.Print(err)
+				continue
+			}
+			if err := os.Remove(path); err != nil {
+				log.Print(err)
+				continue
+			}
+			id := queue.Send(Message{Body: string(body)})
+			log.Printf("%s: queued %s", id, filepath.Base(path))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// writeDeadLetters saves every message moved to the dead-letter queue as
+// dir/<id>.json.
+func writeDeadLetters(ctx context.Context, dir string, queue *Queue, interval time.Duration) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Print(err)
+		return
+	}
+
+	written := 0
+	for {
+		dead := queue.Dead()
+		for _, m := range dead[written:] {
+			buf, _ := json.MarshalIndent(m, "", "  ")
+			if err := os.WriteFile(filepath.Join(dir, m.ID+".json"), buf, 0644); err != nil {
+				log.Print(err)
+			}
+			log.Printf("%s: moved to the dead-letter queue after %d receives", m.ID, m.ReceiveCount)
+		}
+		written = len(dead)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// taskURLs reads the name and url of the tasks in a cron.yaml file.
+func taskURLs(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	urls := map[string]string{}
+	var name, url string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "- ") {
+			// A new entry of the cron list.
+			name, url = "", ""
+			line = strings.TrimPrefix(line, "- ")
+		}
+
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.Trim(strings.TrimSpace(value), `"'`)
+		switch strings.TrimSpace(key) {
+		case "name":
+			name = value
+		case "url":
+			url = value
+		}
+		if name != "" && url != "" {
+			urls[name] = url
+		}
+	}
+	return urls, scanner.Err()
+}
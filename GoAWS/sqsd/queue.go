@@ -0,0 +1,163 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Message is a message on the emulated queue. Task and ScheduledAt are set
+// for the periodic tasks of cron.yaml, which sqsd delivers to the task's URL
+// instead of the worker path.
+type Message struct {
+	ID          string            `json:"id"`
+	Body        string            `json:"body"`
+	Attributes  map[string]string `json:"attributes,omitempty"`
+	Task        string            `json:"task,omitempty"`
+	URL         string            `json:"url,omitempty"`
+	ScheduledAt time.Time         `json:"scheduled_at,omitempty"`
+
+	Sent          time.Time `json:"sent"`
+	FirstReceived time.Time `json:"first_received,omitempty"`
+	ReceiveCount  int       `json:"receive_count"`
+
+	// LastError is why the last delivery failed, for dead letters.
+	LastError string `json:"last_error,omitempty"`
+
+	visibleAt time.Time
+}
+
+// Queue is an in-memory SQS queue with a redrive policy. Like SQS, a received
+// message stays on the queue, invisible, until it is deleted or its visibility
+// timeout runs out, and a message received MaxReceiveCount times without
+// being deleted moves to the dead-letter queue instead of being received
+// again. Messages older than Retention are dropped.
+type Queue struct {
+	Name            string
+	MaxReceiveCount int
+	Retention       time.Duration
+
+	mu       sync.Mutex
+	messages map[string]*Message
+	dead     []Message
+}
+
+func NewQueue(name string, maxReceiveCount int, retention time.Duration) *Queue {
+	return &Queue{
+		Name:            name,
+		MaxReceiveCount: maxReceiveCount,
+		Retention:       retention,
+		messages:        map[string]*Message{},
+	}
+}
+
+// Send adds m to the queue, giving it an ID unless it has one. Sending a
+// message with the ID of one already on the queue replaces it, which is how
+// tests simulate SQS delivering a message twice.
+func (q *Queue) Send(m Message) string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if m.ID == "" {
+		m.ID = newID()
+	}
+	m.Sent = time.Now()
+	m.FirstReceived, m.ReceiveCount, m.visibleAt = time.Time{}, 0, time.Time{}
+	q.messages[m.ID] = &m
+	return m.ID
+}
+
+// Receive returns up to max visible messages, oldest first, hiding them for
+// visibility.
+func (q *Queue) Receive(max int, visibility time.Duration) []Message {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	var visible []*Message
+	for id, m := range q.messages {
+		switch {
+		case q.Retention > 0 && now.Sub(m.Sent) > q.Retention:
+			delete(q.messages, id)
+		case m.visibleAt.After(now):
+		case q.MaxReceiveCount > 0 && m.ReceiveCount >= q.MaxReceiveCount:
+			delete(q.messages, id)
+			q.dead = append(q.dead, *m)
+		default:
+			visible = append(visible, m)
+		}
+	}
+	sort.Slice(visible, func(i, j int) bool { return visible[i].Sent.Before(visible[j].Sent) })
+	if len(visible) > max {
+		visible = visible[:max]
+	}
+
+	received := make([]Message, len(visible))
+	for i, m := range visible {
+		m.ReceiveCount++
+		if m.FirstReceived.IsZero() {
+			m.FirstReceived = now
+		}
+		m.visibleAt = now.Add(visibility)
+		received[i] = *m
+	}
+	return received
+}
+
+// Delete removes a message once it was processed.
+func (q *Queue) Delete(id string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	delete(q.messages, id)
+}
+
+// Fail records a failed delivery, making the message visible again after
+// wait.
+func (q *Queue) Fail(id string, reason string, wait time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if m, ok := q.messages[id]; ok {
+		m.LastError = reason
+		m.visibleAt = time.Now().Add(wait)
+	}
+}
+
+// Timeout records a delivery that got no answer. The message stays invisible
+// until its visibility timeout runs out.
+func (q *Queue) Timeout(id string, reason string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if m, ok := q.messages[id]; ok {
+		m.LastError = reason
+	}
+}
+
+// Len returns the number of messages on the queue, visible or not.
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.messages)
+}
+
+// Dead returns the messages moved to the dead-letter queue.
+func (q *Queue) Dead() []Message {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return append([]Message(nil), q.dead...)
+}
+
+// newID returns a random UUID, the format of SQS message IDs.
+func newID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
@@ -0,0 +1,222 @@
+package main
+
+// The integration tests build the GoAWS application from the parent
+// directory, start it and feed it messages through the emulated daemon. Run
+// them from this directory with
+//
+//	go test *.go
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testConfig shortens the daemon's timeouts so retries happen quickly.
+func testConfig(url string) Config {
+	config := DefaultConfig()
+	config.URL = url
+	config.InactivityTimeout = 500 * time.Millisecond
+	config.VisibilityTimeout = time.Second
+	config.ErrorVisibilityTimeout = 50 * time.Millisecond
+	config.PollInterval = 10 * time.Millisecond
+	return config
+}
+
+// startDaemon runs a daemon for the test's duration.
+func startDaemon(t *testing.T, config Config, maxReceive int) *Queue {
+	t.Helper()
+
+	queue := NewQueue("test-queue", maxReceive, time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		NewDaemon(config, queue).Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return queue
+}
+
+// startApp builds and starts the application, returning its URL and the file
+// where it records processed messages.
+func startApp(t *testing.T) (string, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	sources, err := filepath.Glob("../*.go")
+	if err != nil || len(sources) == 0 {
+		t.Fatalf("no application sources: %v", err)
+	}
+	bin := filepath.Join(dir, "application")
+	build := exec.Command("go", append([]string{"build", "-o", bin}, sources...)...)
+	if out, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("building the application: %v\n%s", err, out)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	ln.Close()
+
+	processed := filepath.Join(dir, "processed-messages.log")
+	cmd := exec.Command(bin)
+	cmd.Dir = ".."
+	cmd.Env = append(os.Environ(), "PORT="+port, "PROCESSED_FILE="+processed, "TASK_HISTORY_FILE="+filepath.Join(dir, "task-history.log"), "DEAD_LETTER_FILE="+filepath.Join(dir, "dead-letters.json"), "QUARANTINE_FILE="+filepath.Join(dir, "quarantine.json"))
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	url := "http://127.0.0.1:" + port
+	waitUntil(t, "the application to start", func() bool {
+		resp, err := http.Get(url + "/")
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return true
+	})
+	return url, processed
+}
+
+func waitUntil(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWorkerMessages(t *testing.T) {
+	url, processed := startApp(t)
+	queue := startDaemon(t, testConfig(url), 3)
+
+	t.Run("delivered", func(t *testing.T) {
+		id := queue.Send(Message{Body: `{"type": "log", "payload": {"text": "hello"}}`})
+		waitUntil(t, "the message to be deleted", func() bool { return queue.Len() == 0 })
+
+		buf, _ := os.ReadFile(processed)
+		if !strings.Contains(string(buf), id+"\t") {
+			t.Errorf("%s was not recorded as processed:\n%s", id, buf)
+		}
+	})
+
+	t.Run("redelivered", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			queue.Send(Message{ID: "redelivered", Body: `{"type": "log", "payload": {}}`})
+			waitUntil(t, "the message to be deleted", func() bool { return queue.Len() == 0 })
+		}
+
+		buf, _ := os.ReadFile(processed)
+		if n := strings.Count(string(buf), "redelivered\t"); n != 1 {
+			t.Errorf("message processed %d times, want once", n)
+		}
+	})
+
+	t.Run("dead letter", func(t *testing.T) {
+		id := queue.Send(Message{Body: `{"type": "unknown"}`})
+		waitUntil(t, "the dead letter", func() bool { return len(queue.Dead()) > 0 })
+
+		dead := queue.Dead()[0]
+		if dead.ID != id || dead.ReceiveCount != 3 || dead.LastError != "400 Bad Request" {
+			t.Errorf("dead letter = %s received %d times, last error %q", dead.ID, dead.ReceiveCount, dead.LastError)
+		}
+		if queue.Len() != 0 {
+			t.Error("dead letter is still on the queue")
+		}
+	})
+}
+
+func TestScheduledTask(t *testing.T) {
+	urls, err := taskURLs("../cron.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if urls["task1"] != "/scheduled" {
+		t.Fatalf("task URLs = %v", urls)
+	}
+
+	url, _ := startApp(t)
+	queue := startDaemon(t, testConfig(url), 3)
+
+	queue.Send(Task("task1", urls["task1"], time.Now()))
+	waitUntil(t, "the task to be delivered", func() bool { return queue.Len() == 0 })
+	if len(queue.Dead()) != 0 {
+		t.Error("the task failed")
+	}
+}
+
+// TestVisibilityTimeout checks the daemon itself: a delivery without an
+// answer is retried only once the visibility timeout has passed, with the
+// sqsd headers.
+func TestVisibilityTimeout(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests []*http.Request
+		times    []time.Time
+	)
+	app := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests, times = append(requests, r), append(times, time.Now())
+		first := len(requests) == 1
+		mu.Unlock()
+
+		if first {
+			time.Sleep(time.Second)
+		}
+	}))
+	defer app.Close()
+
+	config := testConfig(app.URL)
+	queue := startDaemon(t, config, 0)
+	id := queue.Send(Message{Body: "{}", Attributes: map[string]string{"Origin": "test"}})
+	waitUntil(t, "the message to be deleted", func() bool { return queue.Len() == 0 })
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(requests) != 2 {
+		t.Fatalf("%d deliveries, want 2", len(requests))
+	}
+	if wait := times[1].Sub(times[0]); wait < config.VisibilityTimeout {
+		t.Errorf("retried after %v, before the visibility timeout", wait)
+	}
+
+	r := requests[1]
+	for header, want := range map[string]string{
+		"X-Aws-Sqsd-Msgid":         id,
+		"X-Aws-Sqsd-Receive-Count": "2",
+		"X-Aws-Sqsd-Queue":         "test-queue",
+		"X-Aws-Sqsd-Attr-Origin":   "test",
+		"Content-Type":             "application/json",
+	} {
+		if got := r.Header.Get(header); got != want {
+			t.Errorf("%s = %q, want %q", header, got, want)
+		}
+	}
+	if _, err := time.Parse(time.RFC3339, r.Header.Get("X-Aws-Sqsd-First-Received-At")); err != nil {
+		t.Errorf("X-Aws-Sqsd-First-Received-At: %v", err)
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// The Brotli encodings of public are made ahead of time, since the standard
+// library has no Brotli encoder, by a command with its own module that builds
+// the site with a copy of this file.
+//
+//go:generate cp static.go brotli/static.go
+//go:generate go -C brotli run . ../public ../public-br
+
+// notFoundPage is served, with a 404, for every path the site lacks.
+const notFoundPage = "/404.html"
+
+// Cache-Control of the hashed paths, whose content never changes, and of the
+// plain ones, which browsers must revalidate.
+const (
+	cacheImmutable  = "public, max-age=31536000, immutable"
+	cacheRevalidate = "no-cache"
+)
+
+// assetRef matches the local URLs of src and href attributes.
+var assetRef = regexp.MustCompile(`((?:src|href)\s*=\s*["'])(/[^"'?#]*)`)
+
+type asset struct {
+	contentType string
+	body        []byte
+	etag        string
+	hashed      bool
+	// gzip and br are the compressed bodies, nil when not worth it or, for
+	// br, not generated.
+	gzip, br []byte
+}
+
+// StaticSite serves a tree of files from memory. Every file is served at its
+// own path, to be revalidated with its ETag, and at a path with a hash of its
+// content (site.css as site.1a2b3c4d.css) that is cached for good; local
+// src and href URLs in the HTML files are rewritten to the hashed paths. Files
+// are gzipped when loaded; their Brotli encodings are generated at build time
+// and named after the ETag of the body they encode, so that one left from
+// an earlier version of a file is never served.
+type StaticSite struct {
+	assets map[string]*asset
+	// hashed maps the plain paths to the hashed ones.
+	hashed   map[string]string
+	modified time.Time
+}
+
+// NewStaticSite loads the files of fsys, and their Brotli encodings from
+// brotli, which may be nil.
+func NewStaticSite(fsys, brotli fs.FS) (*StaticSite, error) {
+	s := &StaticSite{assets: map[string]*asset{}, hashed: map[string]string{}, modified: time.Now()}
+
+	var pages []string
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		body, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		a := &asset{contentType: mime.TypeByExtension(path.Ext(name)), body: body}
+		if a.contentType == "" {
+			a.contentType = http.DetectContentType(body)
+		}
+
+		p := "/" + name
+		s.assets[p] = a
+		if strings.HasPrefix(a.contentType, "text/html") {
+			// Pages link to the assets by URL, so their own URL must stay.
+			pages = append(pages, p)
+		} else {
+			s.addHashed(p, a)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range pages {
+		a := s.assets[p]
+		a.body = assetRef.ReplaceAllFunc(a.body, func(m []byte) []byte {
+			parts := assetRef.FindSubmatch(m)
+			if hashed, ok := s.hashed[string(parts[2])]; ok {
+				return append(append([]byte{}, parts[1]...), hashed...)
+			}
+			return m
+		})
+	}
+
+	for _, a := range s.assets {
+		sum := sha256.Sum256(a.body)
+		a.etag = `"` + hex.EncodeToString(sum[:8]) + `"`
+		if compressible(a.contentType) {
+			a.gzip = gzipBytes(a.body)
+		}
+		if a.gzip != nil && brotli != nil {
+			a.br, _ = fs.ReadFile(brotli, brotliName(a))
+		}
+	}
+	return s, nil
+}
+
+// addHashed serves a also at its hashed path.
+func (s *StaticSite) addHashed(p string, a *asset) {
+	sum := sha256.Sum256(a.body)
+	ext := path.Ext(p)
+	hashed := strings.TrimSuffix(p, ext) + "." + hex.EncodeToString(sum[:4]) + ext
+
+	s.hashed[p] = hashed
+	s.assets[hashed] = &asset{contentType: a.contentType, body: a.body, hashed: true}
+}
+
+// brotliName is the name of the Brotli encoding of a's body.
+func brotliName(a *asset) string {
+	return strings.Trim(a.etag, `"`) + ".br"
+}
+
+func compressible(contentType string) bool {
+	return strings.HasPrefix(contentType, "text/") ||
+		strings.Contains(contentType, "javascript") ||
+		strings.Contains(contentType, "json") ||
+		strings.Contains(contentType, "xml") ||
+		strings.HasPrefix(contentType, "image/svg")
+}
+
+// gzipBytes compresses b, returning nil if that does not make it smaller.
+func gzipBytes(b []byte) []byte {
+	var buf bytes.Buffer
+	zw, _ := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	zw.Write(b)
+	zw.Close()
+	if buf.Len() >= len(b) {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+func (s *StaticSite) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	p := path.Clean("/" + r.URL.Path)
+	if strings.HasSuffix(r.URL.Path, "/") {
+		p = path.Join(p, "index.html")
+	}
+	a, ok := s.assets[p]
+	if !ok {
+		s.notFound(w, r)
+		return
+	}
+
+	h := w.Header()
+	if a.hashed {
+		h.Set("Cache-Control", cacheImmutable)
+	} else {
+		h.Set("Cache-Control", cacheRevalidate)
+	}
+	s.serve(w, r, a)
+}
+
+// serve writes a in the best encoding the client accepts. ServeContent
+// answers conditional and range requests.
+func (s *StaticSite) serve(w http.ResponseWriter, r *http.Request, a *asset) {
+	h := w.Header()
+	h.Set("Content-Type", a.contentType)
+	h.Set("X-Content-Type-Options", "nosniff")
+
+	body, etag := a.body, a.etag
+	if a.gzip != nil {
+		h.Add("Vary", "Accept-Encoding")
+		encoding := acceptedEncoding(r, a)
+		switch encoding {
+		case "br":
+			body = a.br
+		case "gzip":
+			body = a.gzip
+		}
+		if encoding != "" {
+			// Each encoding is a different representation, with its own tag.
+			h.Set("Content-Encoding", encoding)
+			etag = strings.TrimSuffix(etag, `"`) + "-" + encoding + `"`
+		}
+	}
+	h.Set("ETag", etag)
+	http.ServeContent(w, r, "", s.modified, bytes.NewReader(body))
+}
+
+// acceptedEncoding picks br or gzip if the client takes it and a has it, br
+// first since it is the smaller.
+func acceptedEncoding(r *http.Request, a *asset) string {
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(part, ";")
+		if q := strings.TrimSpace(params); q == "q=0" || q == "q=0.0" {
+			continue
+		}
+		accepted[strings.ToLower(strings.TrimSpace(name))] = true
+	}
+	switch {
+	case a.br != nil && accepted["br"]:
+		return "br"
+	case a.gzip != nil && (accepted["gzip"] || accepted["*"]):
+		return "gzip"
+	}
+	return ""
+}
+
+func (s *StaticSite) notFound(w http.ResponseWriter, r *http.Request) {
+	a, ok := s.assets[notFoundPage]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	h := w.Header()
+	h.Set("Content-Type", a.contentType)
+	h.Set("Cache-Control", cacheRevalidate)
+	w.WriteHeader(http.StatusNotFound)
+	if r.Method != "HEAD" {
+		w.Write(a.body)
+	}
+}
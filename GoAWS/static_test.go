@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"io/fs"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func newTestSite(t *testing.T) *StaticSite {
+	t.Helper()
+
+	public, _ := fs.Sub(publicFiles, "public")
+	brotli, _ := fs.Sub(publicBrotli, "public-br")
+	site, err := NewStaticSite(public, brotli)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return site
+}
+
+// TestBrotliGenerated fails when the site changed but its Brotli encodings
+// were not generated again.
+func TestBrotliGenerated(t *testing.T) {
+	for p, a := range newTestSite(t).assets {
+		if a.gzip != nil && a.br == nil {
+			t.Errorf("%s has no Brotli encoding; run go generate static.go", p)
+		}
+	}
+}
+
+func TestStaticEncoding(t *testing.T) {
+	site := newTestSite(t)
+	index := site.assets["/index.html"]
+	tests := []struct {
+		accept, encoding string
+		body             []byte
+	}{
+		{"gzip, deflate, br", "br", index.br},
+		{"gzip", "gzip", index.gzip},
+		{"br;q=0, gzip", "gzip", index.gzip},
+		{"*", "gzip", index.gzip},
+		{"", "", index.body},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept-Encoding", tt.accept)
+		w := httptest.NewRecorder()
+		site.ServeHTTP(w, r)
+
+		h := w.Header()
+		if h.Get("Content-Encoding") != tt.encoding || !bytes.Equal(w.Body.Bytes(), tt.body) {
+			t.Errorf("Accept-Encoding %q: got encoding %q, want %q", tt.accept, h.Get("Content-Encoding"), tt.encoding)
+		}
+		if h.Get("Vary") != "Accept-Encoding" {
+			t.Errorf("Accept-Encoding %q: Vary = %q", tt.accept, h.Get("Vary"))
+		}
+		if tt.encoding != "" && !strings.HasSuffix(h.Get("ETag"), "-"+tt.encoding+`"`) {
+			t.Errorf("Accept-Encoding %q: ETag %s, want one of its own", tt.accept, h.Get("ETag"))
+		}
+	}
+}
+
+func TestStaleBrotliIgnored(t *testing.T) {
+	css := []byte(strings.Repeat("body { color: black; }\n", 20))
+	site, err := NewStaticSite(fstest.MapFS{
+		"site.css": {Data: css},
+	}, fstest.MapFS{
+		// The encoding of an earlier version of site.css.
+		"0123456789abcdef.br": {Data: []byte("stale")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest("GET", "/site.css", nil)
+	r.Header.Set("Accept-Encoding", "br, gzip")
+	w := httptest.NewRecorder()
+	site.ServeHTTP(w, r)
+	if enc := w.Header().Get("Content-Encoding"); enc != "gzip" {
+		t.Errorf("encoding = %q, want gzip rather than a stale br", enc)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// defaultTaskTimeout bounds the tasks registered without a timeout.
+const defaultTaskTimeout = 5 * time.Minute
+
+// TaskFunc runs a periodic task. It should return once ctx is done.
+type TaskFunc func(ctx context.Context, scheduledAt time.Time) error
+
+type task struct {
+	fn      TaskFunc
+	timeout time.Duration
+	running bool
+}
+
+// TaskRegistry maps the task names of cron.yaml to Go functions. A task never
+// runs twice at the same time: a run due while the previous one is still
+// going is skipped. Every run, skipped or not, is recorded in the history.
+type TaskRegistry struct {
+	mu      sync.Mutex
+	tasks   map[string]*task
+	history *TaskHistory
+}
+
+var (
+	errUnknownTask = errors.New("unknown task")
+	errTaskRunning = errors.New("task is still running")
+)
+
+// NewTaskRegistry returns an empty registry recording the runs in history,
+// which may be nil.
+func NewTaskRegistry(history *TaskHistory) *TaskRegistry {
+	return &TaskRegistry{tasks: map[string]*task{}, history: history}
+}
+
+// Register sets the function run for the named task, cancelled after
+// timeout (defaultTaskTimeout if 0).
+func (tr *TaskRegistry) Register(name string, timeout time.Duration, fn TaskFunc) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	if timeout <= 0 {
+		timeout = defaultTaskTimeout
+	}
+	tr.tasks[name] = &task{fn: fn, timeout: timeout}
+}
+
+func (tr *TaskRegistry) Has(name string) bool {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	_, ok := tr.tasks[name]
+	return ok
+}
+
+// Run runs the named task for the time it was scheduled at. It returns
+// errTaskRunning without running it if the previous run has not finished.
+func (tr *TaskRegistry) Run(ctx context.Context, name string, scheduledAt time.Time) error {
+	tr.mu.Lock()
+	t, ok := tr.tasks[name]
+	if !ok {
+		tr.mu.Unlock()
+		return errUnknownTask
+	}
+	if t.running {
+		tr.mu.Unlock()
+		tr.record(TaskRun{Task: name, ScheduledAt: scheduledAt, Started: time.Now().UTC(), Outcome: RunSkipped, Error: errTaskRunning.Error()})
+		return errTaskRunning
+	}
+	t.running = true
+	tr.mu.Unlock()
+
+	defer func() {
+		tr.mu.Lock()
+		t.running = false
+		tr.mu.Unlock()
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+
+	run := TaskRun{Task: name, ScheduledAt: scheduledAt, Started: time.Now().UTC(), Outcome: RunOK}
+	err := runTask(ctx, t.fn, scheduledAt)
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %v", t.timeout)
+	}
+	run.Duration = time.Since(run.Started)
+	if err != nil {
+		run.Outcome, run.Error = RunFailed, err.Error()
+	}
+	tr.record(run)
+	return err
+}
+
+func (tr *TaskRegistry) record(run TaskRun) {
+	if tr.history == nil {
+		return
+	}
+	if err := tr.history.Record(run); err != nil {
+		log.Printf("Cannot record the run of %s: %v\n", run.Task, err)
+	}
+}
+
+// runTask calls fn, turning a panic into an error.
+func runTask(ctx context.Context, fn TaskFunc, scheduledAt time.Time) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("task panicked: %v", p)
+		}
+	}()
+	return fn(ctx, scheduledAt)
+}
+
+// ServeHTTP runs the task sqsd POSTs to a cron.yaml url. Skipped runs answer
+// 200 too, since retrying them would only run the task late.
+func (tr *TaskRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := r.Header.Get("X-Aws-Sqsd-Taskname")
+	scheduledAt, err := time.Parse(time.RFC3339, r.Header.Get("X-Aws-Sqsd-Scheduled-At"))
+	if err != nil {
+		scheduledAt = time.Now().UTC()
+	}
+	log.Printf("Received task %s scheduled at %s\n", name, scheduledAt.Format(time.RFC3339))
+
+	err = tr.Run(r.Context(), name, scheduledAt)
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusOK)
+	case errors.Is(err, errTaskRunning):
+		log.Printf("Task %s skipped: the previous run is still going\n", name)
+		w.WriteHeader(http.StatusOK)
+	case errors.Is(err, errUnknownTask):
+		log.Printf("Task %q is not registered\n", name)
+		http.Error(w, "unknown task", http.StatusNotFound)
+	default:
+		log.Printf("Task %s failed: %v\n", name, err)
+		http.Error(w, "task failed", http.StatusInternalServerError)
+	}
+}
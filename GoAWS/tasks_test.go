@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestHistory returns a history kept in a temporary directory.
+func newTestHistory(t *testing.T) *TaskHistory {
+	t.Helper()
+
+	h, err := OpenTaskHistory(filepath.Join(t.TempDir(), "history.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { h.Close() })
+	return h
+}
+
+func TestTaskOverlap(t *testing.T) {
+	history := newTestHistory(t)
+	tasks := NewTaskRegistry(history)
+	started, release := make(chan struct{}, 2), make(chan struct{})
+	tasks.Register("slow", time.Minute, func(ctx context.Context, scheduledAt time.Time) error {
+		started <- struct{}{}
+		<-release
+		return nil
+	})
+
+	first := make(chan error, 1)
+	go func() { first <- tasks.Run(context.Background(), "slow", time.Now()) }()
+	<-started
+
+	if err := tasks.Run(context.Background(), "slow", time.Now()); !errors.Is(err, errTaskRunning) {
+		t.Errorf("overlapping run = %v, want errTaskRunning", err)
+	}
+
+	// sqsd must not retry a skipped run.
+	r := httptest.NewRequest("POST", "/scheduled", nil)
+	r.Header.Set("X-Aws-Sqsd-Taskname", "slow")
+	w := httptest.NewRecorder()
+	tasks.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("overlapping run over HTTP: status %d, want 200", w.Code)
+	}
+
+	close(release)
+	if err := <-first; err != nil {
+		t.Fatalf("first run = %v", err)
+	}
+
+	runs := history.Runs("slow")
+	if len(runs) != 3 {
+		t.Fatalf("%d runs recorded, want 3", len(runs))
+	}
+	if runs[0].Outcome != RunOK || runs[1].Outcome != RunSkipped || runs[2].Outcome != RunSkipped {
+		t.Errorf("outcomes %s, %s, %s, want ok, skipped, skipped", runs[0].Outcome, runs[1].Outcome, runs[2].Outcome)
+	}
+
+	// Once finished, the task runs again.
+	go func() { first <- tasks.Run(context.Background(), "slow", time.Now()) }()
+	select {
+	case err := <-first:
+		if err != nil {
+			t.Errorf("run after the first finished = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("run after the first finished did not return")
+	}
+}
+
+func TestTaskTimeout(t *testing.T) {
+	history := newTestHistory(t)
+	tasks := NewTaskRegistry(history)
+	tasks.Register("stuck", 20*time.Millisecond, func(ctx context.Context, scheduledAt time.Time) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	err := tasks.Run(context.Background(), "stuck", time.Now())
+	if err == nil || !strings.Contains(err.Error(), "timed out after 20ms") {
+		t.Fatalf("Run = %v, want a timeout", err)
+	}
+	runs := history.Runs("stuck")
+	if len(runs) != 1 || runs[0].Outcome != RunFailed || !strings.Contains(runs[0].Error, "timed out") {
+		t.Errorf("recorded %+v, want a failed run that timed out", runs)
+	}
+}
+
+func TestTaskErrors(t *testing.T) {
+	tasks := NewTaskRegistry(nil)
+	tasks.Register("panics", time.Minute, func(ctx context.Context, scheduledAt time.Time) error {
+		panic("boom")
+	})
+
+	if err := tasks.Run(context.Background(), "panics", time.Now()); err == nil || !strings.Contains(err.Error(), "task panicked: boom") {
+		t.Errorf("panicking task = %v", err)
+	}
+	if err := tasks.Run(context.Background(), "missing", time.Now()); !errors.Is(err, errUnknownTask) {
+		t.Errorf("unknown task = %v, want errUnknownTask", err)
+	}
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxMessageSize is the largest body sqsd can deliver (the SQS limit).
+const maxMessageSize = 256 * 1024
+
+// Envelope is the JSON body of every message on the worker queue. Type picks
+// the handler and Payload is passed to it as is.
+type Envelope struct {
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// MessageHandler processes the payload of one message. Returning an error
+// leaves the message on the queue, so it must be safe to run again. ctx is
+// cancelled when the message's processing timeout runs out.
+type MessageHandler func(ctx context.Context, msg Message) error
+
+// Message is a delivery from the SQS daemon.
+type Message struct {
+	ID            string
+	Type          string
+	Payload       json.RawMessage
+	ReceiveCount  string
+	FirstReceived string
+}
+
+// Registry maps message types to their handlers, and to the schemas their
+// payloads must match.
+type Registry struct {
+	mu       sync.RWMutex
+	handlers map[string]MessageHandler
+	schemas  map[string]*Schema
+}
+
+func NewRegistry() *Registry {
+	return &Registry{handlers: map[string]MessageHandler{}, schemas: map[string]*Schema{}}
+}
+
+// Register sets the handler for messages of type typ.
+func (r *Registry) Register(typ string, h MessageHandler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.handlers[typ] = h
+}
+
+func (r *Registry) Handler(typ string) (MessageHandler, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	h, ok := r.handlers[typ]
+	return h, ok
+}
+
+// SetSchema sets the schema the payload of messages of type typ must match.
+func (r *Registry) SetSchema(typ string, s *Schema) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.schemas[typ] = s
+}
+
+// Schema returns the schema of a message type, or nil if it has none.
+func (r *Registry) Schema(typ string) *Schema {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.schemas[typ]
+}
+
+// Worker answers the messages the SQS daemon POSTs to "/". sqsd deletes a
+// message from the queue on a 200 and retries it after the visibility timeout
+// on anything else, so every failure must end in a non-2xx status. Failed
+// messages are kept in dead until they are processed.
+//
+// Invalid messages, which no retry can fix, are the exception: they are moved
+// to quarantine with the reason and answered with a 200, so sqsd drops them.
+//
+// Handlers run in pool, and are given timeout to process a message.
+type Worker struct {
+	registry   *Registry
+	processed  *ProcessedSet
+	dead       *DeadLetters
+	quarantine *DeadLetters
+	pool       *Pool
+	timeout    time.Duration
+
+	// draining is set once the worker takes no new messages.
+	draining atomic.Bool
+	// running holds the IDs of the messages being handled; wg counts their
+	// handlers.
+	mu      sync.Mutex
+	running map[string]bool
+	wg      sync.WaitGroup
+}
+
+func NewWorker(registry *Registry, processed *ProcessedSet, dead, quarantine *DeadLetters, pool *Pool, timeout time.Duration) *Worker {
+	return &Worker{
+		registry:   registry,
+		processed:  processed,
+		dead:       dead,
+		quarantine: quarantine,
+		pool:       pool,
+		timeout:    timeout,
+		running:    map[string]bool{},
+	}
+}
+
+var (
+	errInFlight     = errors.New("message is already being processed")
+	errBadMessage   = errors.New("malformed message")
+	errInvalid      = errors.New("invalid message")
+	errTimedOut     = errors.New("processing timed out")
+	errInterrupted  = errors.New("processing interrupted")
+	errShuttingDown = errors.New("shutting down")
+	errNoDeadLetter = errors.New("no such dead letter")
+)
+
+// quarantinedBody is how much of a message too big to read is kept.
+const quarantinedBody = 4096
+
+func (wk *Worker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := r.Header.Get("X-Aws-Sqsd-Msgid")
+	if id == "" {
+		http.Error(w, "missing X-Aws-Sqsd-Msgid header", http.StatusBadRequest)
+		return
+	}
+
+	msg := Message{
+		ID:            id,
+		ReceiveCount:  r.Header.Get("X-Aws-Sqsd-Receive-Count"),
+		FirstReceived: r.Header.Get("X-Aws-Sqsd-First-Received-At"),
+	}
+
+	// Read at most maxMessageSize bytes, so a huge body cannot exhaust the
+	// memory.
+	buf, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxMessageSize))
+	var tooBig *http.MaxBytesError
+	if errors.As(err, &tooBig) {
+		if len(buf) > quarantinedBody {
+			buf = buf[:quarantinedBody]
+		}
+		err = fmt.Errorf("%w: body exceeds %d bytes", errInvalid, tooBig.Limit)
+	} else if err != nil {
+		log.Printf("Message %s: reading body: %v\n", id, err)
+		http.Error(w, "cannot read message", http.StatusBadRequest)
+		return
+	} else {
+		ctx, cancel := context.WithTimeout(r.Context(), wk.timeout)
+		err = wk.process(ctx, msg, buf)
+		cancel()
+	}
+
+	wk.track(msg, buf, err)
+	workerOutcomes.Add(outcome(err), 1)
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusOK)
+	case errors.Is(err, errBusy):
+		// Saturated: sqsd retries the message after its error visibility
+		// timeout, by which time workers should be free.
+		w.Header().Set("Retry-After", "5")
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+	case errors.Is(err, errOverloaded), errors.Is(err, errWaitCanceled), errors.Is(err, errShuttingDown), errors.Is(err, errInterrupted):
+		w.Header().Set("Retry-After", "10")
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	case errors.Is(err, errTimedOut):
+		log.Printf("Message %s (receive count %s): %v\n", id, msg.ReceiveCount, err)
+		http.Error(w, err.Error(), http.StatusGatewayTimeout)
+	case errors.Is(err, errInvalid):
+		log.Printf("Message %s quarantined: %v\n", id, err)
+		http.Error(w, "quarantined: "+err.Error(), http.StatusOK)
+	case errors.Is(err, errInFlight):
+		// A redelivery while the first attempt still runs: have sqsd come
+		// back later, when the outcome is known.
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, errBadMessage):
+		log.Printf("Message %s (receive count %s): %v\n", id, msg.ReceiveCount, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		log.Printf("Message %s (receive count %s) failed: %v\n", id, msg.ReceiveCount, err)
+		http.Error(w, "processing failed", http.StatusInternalServerError)
+	}
+}
+
+// Replay processes a dead letter or a quarantined message again through the
+// registry, removing it if it succeeds.
+func (wk *Worker) Replay(id string) error {
+	l, ok := wk.dead.Get(id)
+	if !ok {
+		if l, ok = wk.quarantine.Get(id); !ok {
+			return errNoDeadLetter
+		}
+	}
+
+	msg := Message{ID: l.ID, ReceiveCount: l.ReceiveCount, FirstReceived: l.FirstReceived}
+	ctx, cancel := context.WithTimeout(context.Background(), wk.timeout)
+	defer cancel()
+	err := wk.process(ctx, msg, []byte(l.Body))
+	wk.track(msg, []byte(l.Body), err)
+	if err == nil {
+		log.Printf("Message %s replayed\n", id)
+	} else {
+		log.Printf("Message %s failed again on replay: %v\n", id, err)
+	}
+	return err
+}
+
+// track records the outcome of processing msg in the dead letters or the
+// quarantine.
+func (wk *Worker) track(msg Message, body []byte, err error) {
+	var saveErr error
+	switch {
+	case err == nil:
+		saveErr = errors.Join(wk.dead.Remove(msg.ID), wk.quarantine.Remove(msg.ID))
+	case errors.Is(err, errInFlight), errors.Is(err, errBusy), errors.Is(err, errOverloaded), errors.Is(err, errWaitCanceled), errors.Is(err, errShuttingDown):
+		// The message was not processed this time: the other delivery's, or
+		// the retry's, outcome counts.
+	case errors.Is(err, errInvalid):
+		saveErr = errors.Join(wk.quarantine.Fail(msg, body, err), wk.dead.Remove(msg.ID))
+	default:
+		saveErr = errors.Join(wk.dead.Fail(msg, body, err), wk.quarantine.Remove(msg.ID))
+	}
+	if saveErr != nil {
+		log.Printf("Message %s: cannot save the failed messages: %v\n", msg.ID, saveErr)
+	}
+}
+
+// outcome names the result of processing a message in the metrics.
+func outcome(err error) string {
+	switch {
+	case err == nil:
+		return "ok"
+	case errors.Is(err, errInFlight):
+		return "in_flight"
+	case errors.Is(err, errBusy), errors.Is(err, errOverloaded), errors.Is(err, errWaitCanceled), errors.Is(err, errShuttingDown):
+		return "rejected"
+	case errors.Is(err, errInvalid):
+		return "quarantined"
+	case errors.Is(err, errBadMessage):
+		return "bad_message"
+	case errors.Is(err, errTimedOut):
+		return "timed_out"
+	case errors.Is(err, errInterrupted):
+		return "interrupted"
+	}
+	return "failed"
+}
+
+// process runs the handler for a message unless it was processed before.
+// The handler gets a worker from the pool; if ctx is done before it returns
+// the message is answered as failed, but its outcome is still recorded when
+// it does, and it keeps the worker until then.
+func (wk *Worker) process(ctx context.Context, msg Message, body []byte) error {
+	if wk.draining.Load() {
+		return errShuttingDown
+	}
+
+	var env Envelope
+	if err := json.Unmarshal(body, &env); err != nil || env.Type == "" {
+		return fmt.Errorf("%w: want a JSON object with a \"type\"", errInvalid)
+	}
+	msg.Type, msg.Payload = env.Type, env.Payload
+
+	h, ok := wk.registry.Handler(env.Type)
+	if !ok {
+		return fmt.Errorf("%w: no handler for type %q", errBadMessage, env.Type)
+	}
+	if schema := wk.registry.Schema(env.Type); schema != nil {
+		if err := schema.Validate(env.Payload); err != nil {
+			return fmt.Errorf("%w: %v", errInvalid, err)
+		}
+	}
+
+	done, err := wk.processed.Begin(msg.ID)
+	if err != nil {
+		return err
+	}
+	if done {
+		log.Printf("Message %s was already processed, skipping\n", msg.ID)
+		return nil
+	}
+
+	if err := wk.pool.Acquire(ctx); err != nil {
+		wk.processed.Abort(msg.ID)
+		return err
+	}
+	// Checked again under the lock, so that Drain waits for every handler
+	// started.
+	wk.mu.Lock()
+	if wk.draining.Load() {
+		wk.mu.Unlock()
+		wk.pool.Release()
+		wk.processed.Abort(msg.ID)
+		return errShuttingDown
+	}
+	wk.running[msg.ID] = true
+	wk.wg.Add(1)
+	wk.mu.Unlock()
+
+	result := make(chan error, 1)
+	go func() {
+		defer wk.wg.Done()
+		defer func() {
+			wk.mu.Lock()
+			delete(wk.running, msg.ID)
+			wk.mu.Unlock()
+		}()
+		defer wk.pool.Release()
+
+		err := run(ctx, h, msg)
+		if err != nil {
+			wk.processed.Abort(msg.ID)
+		} else {
+			err = wk.processed.Finish(msg.ID)
+		}
+		result <- err
+	}()
+
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("%w after %v", errTimedOut, wk.timeout)
+		}
+		return fmt.Errorf("%w: %v", errInterrupted, ctx.Err())
+	}
+}
+
+// Drain stops the worker taking messages, which are answered with a 503 for
+// sqsd to deliver them again later, and waits for the handlers running. If
+// ctx is done first, it returns the IDs of the messages still being
+// processed.
+func (wk *Worker) Drain(ctx context.Context) []string {
+	wk.mu.Lock()
+	wk.draining.Store(true)
+	wk.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		wk.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	wk.mu.Lock()
+	defer wk.mu.Unlock()
+
+	ids := make([]string, 0, len(wk.running))
+	for id := range wk.running {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// run calls h, turning a panic into an error so the message is retried
+// rather than the server crashing.
+func run(ctx context.Context, h MessageHandler, msg Message) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("handler panicked: %v", p)
+		}
+	}()
+	return h(ctx, msg)
+}
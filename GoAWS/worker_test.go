@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestWorker returns a worker keeping its state in a temporary directory,
+// with h as the handler of "log" messages and the schema of schemas/log.json.
+func newTestWorker(t *testing.T, h MessageHandler, pool *Pool) *Worker {
+	t.Helper()
+
+	dir := t.TempDir()
+	registry := NewRegistry()
+	registry.Register("log", h)
+	if err := LoadSchemas("schemas", registry); err != nil {
+		t.Fatal(err)
+	}
+	processed, err := OpenProcessedSet(filepath.Join(dir, "processed.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead, err := OpenDeadLetters(filepath.Join(dir, "dead.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { dead.Close() })
+	quarantine, err := OpenDeadLetters(filepath.Join(dir, "quarantine.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { quarantine.Close() })
+	return NewWorker(registry, processed, dead, quarantine, pool, time.Second)
+}
+
+// deliver POSTs body to the worker as sqsd would, as message id.
+func deliver(wk *Worker, id, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", "/", strings.NewReader(body))
+	r.Header.Set("X-Aws-Sqsd-Msgid", id)
+	r.Header.Set("X-Aws-Sqsd-Receive-Count", "1")
+	w := httptest.NewRecorder()
+	wk.ServeHTTP(w, r)
+	return w
+}
+
+func TestQuarantine(t *testing.T) {
+	var calls atomic.Int32
+	wk := newTestWorker(t, func(ctx context.Context, msg Message) error {
+		calls.Add(1)
+		return nil
+	}, NewPool(1, 1, time.Second))
+
+	tests := []struct {
+		id     string
+		body   string
+		reason string
+	}{
+		{"m1", `{"type": "log", "payload": {"text": 1}}`, "payload.text: want string, got integer"},
+		{"m2", `{"type": "log", "payload": {"text": "a", "level": "info"}}`, `unexpected property "level"`},
+		{"m3", `not json`, `want a JSON object with a "type"`},
+	}
+	for _, tt := range tests {
+		w := deliver(wk, tt.id, tt.body)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: status %d, want 200 so that sqsd drops it", tt.id, w.Code)
+		}
+		if !strings.Contains(w.Body.String(), "quarantined: ") {
+			t.Errorf("%s: body %q does not say it is quarantined", tt.id, w.Body)
+		}
+		l, ok := wk.quarantine.Get(tt.id)
+		if !ok {
+			t.Errorf("%s: not in the quarantine", tt.id)
+			continue
+		}
+		if !strings.Contains(l.Error, tt.reason) {
+			t.Errorf("%s: quarantined for %q, want %q", tt.id, l.Error, tt.reason)
+		}
+		if l.Body != tt.body {
+			t.Errorf("%s: quarantined body %q, want %q", tt.id, l.Body, tt.body)
+		}
+		if _, ok := wk.dead.Get(tt.id); ok {
+			t.Errorf("%s: quarantined message is also a dead letter", tt.id)
+		}
+	}
+	if n := calls.Load(); n != 0 {
+		t.Errorf("handler called %d times for invalid messages", n)
+	}
+
+	if w := deliver(wk, "m4", `{"type": "log", "payload": {"text": "hello"}}`); w.Code != http.StatusOK {
+		t.Errorf("valid message: status %d, want 200", w.Code)
+	}
+	if _, ok := wk.quarantine.Get("m4"); ok {
+		t.Error("valid message quarantined")
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("handler called %d times for a valid message, want 1", n)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	started := make(chan string, 2)
+	release := make(chan struct{})
+	// The handler ignores ctx, as a stuck one would.
+	wk := newTestWorker(t, func(ctx context.Context, msg Message) error {
+		started <- msg.ID
+		<-release
+		return nil
+	}, NewPool(2, 0, time.Second))
+
+	answered := make(chan int, 2)
+	for _, id := range []string{"m2", "m1"} {
+		go func() {
+			answered <- deliver(wk, id, `{"type": "log", "payload": {"text": "hello"}}`).Code
+		}()
+		<-started
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if ids := wk.Drain(ctx); !reflect.DeepEqual(ids, []string{"m1", "m2"}) {
+		t.Errorf("Drain past its deadline = %q, want the running m1 and m2", ids)
+	}
+
+	w := deliver(wk, "m3", `{"type": "log", "payload": {"text": "hello"}}`)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("message while draining: status %d, want 503", w.Code)
+	}
+
+	close(release)
+	if ids := wk.Drain(context.Background()); ids != nil {
+		t.Errorf("Drain once the handlers returned = %q, want none", ids)
+	}
+	for range 2 {
+		if code := <-answered; code != http.StatusOK {
+			t.Errorf("drained message: status %d, want 200", code)
+		}
+	}
+}
@@ -16,20 +16,49 @@ sould cancel the work and return as soon as possible.
 
 The conext's Err() method returns an error that explains why the Done() channel was closed.
 
+The request's context ends with the request, so the work of /hello is lost once the client leaves.
+Work that must outlive the request runs as a job with a context of its own, derived from
+context.Background() and not from the request:
 
-As before, we register our handler on the '/hello' route, and start serving.
+	POST /jobs?steps=10&step=1s	start a job of 10 steps of 1s, answering 202 with its status
+	GET /jobs/{id}			poll the status and progress of a job
+	DELETE /jobs/{id}		cancel a job, through the cancel function of its context
+	GET /jobs/{id}/events		follow the progress as Server-Sent Events
+
+A job that runs longer than maxJobTime is stopped by the deadline of its context. Once finished, a
+job is kept for jobTTL so that its result can be read, then cleaned up. At most maxRunningJobs run
+at once; beyond that POST /jobs answers 429 Too Many Requests.
+
+As before, we register our handlers, and start serving.
 Run the server in the background.
 Simulate a client request to /hello, hitting Ctr+C shortly after start to signal cancellation.
+Start a job with curl -si -XPOST localhost:8090/jobs, follow it with curl -N on its events and
+cancel it with curl -XDELETE.
 */
 
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"strconv"
+	"sync"
 	"time"
 )
 
+const (
+	maxJobSteps    = 1000
+	maxJobTime     = 10 * time.Minute
+	jobTTL         = 5 * time.Minute
+	maxRunningJobs = 100
+)
+
 func hello(w http.ResponseWriter, req *http.Request) {
 	ctx := req.Context()
 	fmt.Println("Server: hello handler started")
@@ -46,7 +75,251 @@ func hello(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// Job states.
+const (
+	jobRunning  = "running"
+	jobDone     = "done"
+	jobCanceled = "canceled"
+	jobFailed   = "failed"
+)
+
+// JobStatus is what clients see of a job.
+type JobStatus struct {
+	ID       string     `json:"id"`
+	State    string     `json:"state"`
+	Step     int        `json:"step"`
+	Steps    int        `json:"steps"`
+	Progress int        `json:"progress"`
+	Error    string     `json:"error,omitempty"`
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished,omitempty"`
+}
+
+type job struct {
+	cancel context.CancelFunc
+
+	mu     sync.Mutex
+	status JobStatus
+	// changed is closed, and replaced, whenever the status changes.
+	changed chan struct{}
+}
+
+// watch returns the status and a channel closed at its next change.
+func (j *job) watch() (JobStatus, <-chan struct{}) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.status, j.changed
+}
+
+func (j *job) update(f func(s *JobStatus)) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	f(&j.status)
+	close(j.changed)
+	j.changed = make(chan struct{})
+}
+
+type jobs struct {
+	mu      sync.Mutex
+	jobs    map[string]*job
+	running int
+}
+
+func newJobs() *jobs {
+	return &jobs{jobs: map[string]*job{}}
+}
+
+func (s *jobs) get(id string) (*job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[id]
+	return j, ok
+}
+
+// start runs a job of steps steps. Its context is not the request's, so it
+// goes on once the client leaves. It returns false if maxRunningJobs are
+// running already.
+func (s *jobs) start(steps int, step time.Duration) (*job, bool) {
+	id := newJobID()
+	s.mu.Lock()
+	if s.running >= maxRunningJobs {
+		s.mu.Unlock()
+		return nil, false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), maxJobTime)
+	j := &job{
+		cancel:  cancel,
+		status:  JobStatus{ID: id, State: jobRunning, Steps: steps, Started: time.Now()},
+		changed: make(chan struct{}),
+	}
+	s.jobs[id] = j
+	s.running++
+	s.mu.Unlock()
+
+	go func() {
+		defer cancel()
+		err := work(ctx, j, steps, step)
+
+		s.mu.Lock()
+		s.running--
+		s.mu.Unlock()
+
+		j.update(func(st *JobStatus) {
+			now := time.Now()
+			st.Finished = &now
+			switch {
+			case err == nil:
+				st.State = jobDone
+			case errors.Is(err, context.Canceled):
+				st.State = jobCanceled
+			default:
+				st.State, st.Error = jobFailed, err.Error()
+			}
+		})
+		fmt.Printf("server: job %s ended: %v\n", id, err)
+	}()
+	return j, true
+}
+
+// work simulates a long task, checking ctx between its steps.
+func work(ctx context.Context, j *job, steps int, step time.Duration) error {
+	for i := 1; i <= steps; i++ {
+		select {
+		case <-time.After(step):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		j.update(func(st *JobStatus) {
+			st.Step, st.Progress = i, i*100/steps
+		})
+	}
+	return nil
+}
+
+// cleanup drops the jobs finished more than jobTTL ago, every interval.
+func (s *jobs) cleanup(interval time.Duration) {
+	for range time.Tick(interval) {
+		s.mu.Lock()
+		for id, j := range s.jobs {
+			st, _ := j.watch()
+			if st.Finished != nil && time.Since(*st.Finished) > jobTTL {
+				delete(s.jobs, id)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+func newJobID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (s *jobs) create(w http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+	steps, step := 10, time.Second
+	if v := q.Get("steps"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxJobSteps {
+			http.Error(w, fmt.Sprintf("steps must be between 1 and %d", maxJobSteps), http.StatusBadRequest)
+			return
+		}
+		steps = n
+	}
+	if v := q.Get("step"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			http.Error(w, "step must be a positive duration", http.StatusBadRequest)
+			return
+		}
+		step = d
+	}
+
+	j, ok := s.start(steps, step)
+	if !ok {
+		w.Header().Set("Retry-After", "10")
+		http.Error(w, fmt.Sprintf("%d jobs are running already", maxRunningJobs), http.StatusTooManyRequests)
+		return
+	}
+	st, _ := j.watch()
+	w.Header().Set("Location", "/jobs/"+st.ID)
+	writeJSON(w, http.StatusAccepted, st)
+}
+
+func (s *jobs) status(w http.ResponseWriter, req *http.Request) {
+	j, ok := s.get(req.PathValue("id"))
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+	st, _ := j.watch()
+	writeJSON(w, http.StatusOK, st)
+}
+
+// cancel cancels the job's context; the job stops at its next check of it.
+func (s *jobs) cancel(w http.ResponseWriter, req *http.Request) {
+	j, ok := s.get(req.PathValue("id"))
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+	if st, _ := j.watch(); st.State != jobRunning {
+		writeJSON(w, http.StatusConflict, st)
+		return
+	}
+	j.cancel()
+	st, _ := j.watch()
+	writeJSON(w, http.StatusAccepted, st)
+}
+
+// events streams the job's status as Server-Sent Events, a "progress" event
+// at each step and an "end" event once it is finished. A client leaving ends
+// the stream, not the job.
+func (s *jobs) events(w http.ResponseWriter, req *http.Request) {
+	j, ok := s.get(req.PathValue("id"))
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	rc := http.NewResponseController(w)
+
+	for {
+		st, changed := j.watch()
+		event := "progress"
+		if st.State != jobRunning {
+			event = "end"
+		}
+		data, _ := json.Marshal(st)
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+		if err := rc.Flush(); err != nil || event == "end" {
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
+
 func main() {
+	jobs := newJobs()
+	go jobs.cleanup(time.Minute)
+
 	http.HandleFunc("/hello", hello)
-	http.ListenAndServe(":8090", nil)
+	http.HandleFunc("POST /jobs", jobs.create)
+	http.HandleFunc("GET /jobs/{id}", jobs.status)
+	http.HandleFunc("DELETE /jobs/{id}", jobs.cancel)
+	http.HandleFunc("GET /jobs/{id}/events", jobs.events)
+	log.Fatal(http.ListenAndServe(":8090", nil))
 }
@@ -14,39 +14,256 @@ The response writer is used to fill in the HTTP response. Here our multiple resp
 This handler does something a little more sophisticated by reading all the HTTP request headers and
 ecoing them into the response body.
 
+It is grown into an echo service, handy to debug proxies and load balancers: it answers with a JSON
+description of the request it got, its method, URL, query, headers, body, the addresses of the
+client and of the proxies it went through (X-Forwarded-For, X-Real-IP and Forwarded) and, over
+HTTPS, the TLS connection. Clients can send these headers too, so only the entry added last, by the
+proxy in front of the server, is taken as the client's address; the others may be forged. Bodies
+over 1MB are cut in the echo, but body_bytes still counts all of them. Query parameters make it
+behave like a slow or failing backend:
+
+	delay=2s	wait before answering (at most 30s), giving up if the client leaves
+	status=503	answer with this status code, with no body for 204 and 304
+	size=4096	pad the response with this many bytes
+
+Every path under /echo/ is echoed, as is /headers.
+
 We register our handlers on server routes using the http.HandleFunc convenience function. It sets up
 the default router in the net/http package and takes a function as an argument.
 
 Finally we call the ListenAndServe with the port and a handler.
 
-nil tells it to use the default router we've just set up.
+nil tells it to use the default router we've just set up. With -cert and -key it serves HTTPS.
 
 Run the server in the background.
 Access the /hello route.
+Access the /headers and /echo/ routes: curl -s 'localhost:8090/echo/x?delay=1s&status=502'
 */
 
 package main
 
 import (
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
+	"log"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 )
 
+const (
+	maxEchoBody  = 1 << 20
+	maxEchoDelay = 30 * time.Second
+	maxEchoSize  = 10 << 20
+)
+
+type echoTLS struct {
+	Version            string   `json:"version"`
+	CipherSuite        string   `json:"cipher_suite"`
+	ServerName         string   `json:"server_name,omitempty"`
+	NegotiatedProtocol string   `json:"negotiated_protocol,omitempty"`
+	Resumed            bool     `json:"resumed"`
+	PeerCertificates   []string `json:"peer_certificates,omitempty"`
+}
+
+type echoResponse struct {
+	Method  string              `json:"method"`
+	URL     string              `json:"url"`
+	Proto   string              `json:"proto"`
+	Host    string              `json:"host"`
+	Path    string              `json:"path"`
+	Query   map[string][]string `json:"query"`
+	Headers map[string][]string `json:"headers"`
+
+	Body          string `json:"body,omitempty"`
+	BodyBase64    string `json:"body_base64,omitempty"`
+	BodyBytes     int    `json:"body_bytes"`
+	BodyTruncated bool   `json:"body_truncated,omitempty"`
+
+	RemoteAddr   string   `json:"remote_addr"`
+	ClientIP     string   `json:"client_ip"`
+	ForwardedFor []string `json:"forwarded_for,omitempty"`
+	RealIP       string   `json:"real_ip,omitempty"`
+	Forwarded    []string `json:"forwarded,omitempty"`
+
+	TLS *echoTLS `json:"tls,omitempty"`
+
+	Status  int    `json:"status"`
+	Delay   string `json:"delay,omitempty"`
+	Padding string `json:"padding,omitempty"`
+}
+
 func hello(w http.ResponseWriter, req *http.Request) {
 	fmt.Fprintf(w, "hello \n")
 }
 
 func headers(w http.ResponseWriter, req *http.Request) {
-	for name, headers := range req.Header {
-		for _, h := range headers {
-			fmt.Fprintf(w, "%v: %v \n", name, h)
+	q := req.URL.Query()
+
+	status := http.StatusOK
+	if v := q.Get("status"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 200 || n > 599 {
+			http.Error(w, "status must be between 200 and 599", http.StatusBadRequest)
+			return
+		}
+		status = n
+	}
+
+	var delay time.Duration
+	if v := q.Get("delay"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 || d > maxEchoDelay {
+			http.Error(w, fmt.Sprintf("delay must be a duration up to %v", maxEchoDelay), http.StatusBadRequest)
+			return
+		}
+		delay = d
+	}
+
+	size := 0
+	if v := q.Get("size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > maxEchoSize {
+			http.Error(w, fmt.Sprintf("size must be between 0 and %d", maxEchoSize), http.StatusBadRequest)
+			return
+		}
+		size = n
+	}
+
+	body, err := io.ReadAll(io.LimitReader(req.Body, maxEchoBody))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// The rest is only counted.
+	rest, err := io.Copy(io.Discard, req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	echo := echoResponse{
+		Method:     req.Method,
+		URL:        req.URL.String(),
+		Proto:      req.Proto,
+		Host:       req.Host,
+		Path:       req.URL.Path,
+		Query:      q,
+		Headers:    req.Header,
+		BodyBytes:  len(body) + int(rest),
+		RemoteAddr: req.RemoteAddr,
+		RealIP:     req.Header.Get("X-Real-Ip"),
+		Forwarded:  req.Header.Values("Forwarded"),
+		TLS:        tlsInfo(req.TLS),
+		Status:     status,
+		Padding:    strings.Repeat("x", size),
+	}
+	echo.BodyTruncated = rest > 0
+	if utf8.Valid(body) {
+		echo.Body = string(body)
+	} else {
+		echo.BodyBase64 = base64.StdEncoding.EncodeToString(body)
+	}
+
+	echo.ClientIP, _, _ = net.SplitHostPort(req.RemoteAddr)
+	for _, v := range req.Header.Values("X-Forwarded-For") {
+		for _, ip := range strings.Split(v, ",") {
+			echo.ForwardedFor = append(echo.ForwardedFor, strings.TrimSpace(ip))
 		}
 	}
+	// Each proxy appends the address it got the request from, so the last
+	// entry is the one added by the proxy in front of us; the ones before it
+	// are whatever the client sent.
+	if len(echo.ForwardedFor) > 0 {
+		echo.ClientIP = echo.ForwardedFor[len(echo.ForwardedFor)-1]
+	} else if ip := forwardedFor(echo.Forwarded); ip != "" {
+		echo.ClientIP = ip
+	} else if echo.RealIP != "" {
+		echo.ClientIP = echo.RealIP
+	}
+
+	if delay > 0 {
+		echo.Delay = delay.String()
+		select {
+		case <-time.After(delay):
+		case <-req.Context().Done():
+			log.Printf("%s %s: client left during the delay", req.Method, req.URL)
+			return
+		}
+	}
+
+	// 204 and 304 responses cannot have a body.
+	if status == http.StatusNoContent || status == http.StatusNotModified {
+		w.WriteHeader(status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(echo)
+}
+
+// forwardedFor returns the last for= address of the Forwarded headers, the
+// one added by the nearest proxy.
+func forwardedFor(values []string) string {
+	ip := ""
+	for _, v := range values {
+		for _, element := range strings.Split(v, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, value, _ := strings.Cut(strings.TrimSpace(pair), "=")
+				if strings.EqualFold(key, "for") {
+					value = strings.Trim(value, `"`)
+					if host, _, err := net.SplitHostPort(value); err == nil {
+						value = host
+					}
+					ip = strings.Trim(value, "[]")
+				}
+			}
+		}
+	}
+	return ip
+}
+
+func tlsInfo(state *tls.ConnectionState) *echoTLS {
+	if state == nil {
+		return nil
+	}
+	info := &echoTLS{
+		Version:            tls.VersionName(state.Version),
+		CipherSuite:        tls.CipherSuiteName(state.CipherSuite),
+		ServerName:         state.ServerName,
+		NegotiatedProtocol: state.NegotiatedProtocol,
+		Resumed:            state.DidResume,
+	}
+	for _, cert := range state.PeerCertificates {
+		info.PeerCertificates = append(info.PeerCertificates, cert.Subject.String())
+	}
+	return info
 }
 
 func main() {
+	addr := flag.String("addr", ":8090", "address to listen on")
+	cert := flag.String("cert", "", "TLS certificate file, to serve HTTPS")
+	key := flag.String("key", "", "TLS key file")
+	flag.Parse()
+
 	http.HandleFunc("/hello", hello)
 	http.HandleFunc("/headers", headers)
+	http.HandleFunc("/echo/", headers)
 
-	http.ListenAndServe(":8090", nil)
+	var err error
+	if *cert != "" {
+		err = http.ListenAndServeTLS(*addr, *cert, *key, nil)
+	} else {
+		err = http.ListenAndServe(*addr, nil)
+	}
+	log.Fatal(err)
 }
@@ -0,0 +1,468 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// APIClient talks to the SecureForo server.
+type APIClient struct {
+	baseURL string
+	http    *http.Client
+}
+
+func NewAPIClient(baseURL string) *APIClient {
+	return &APIClient{
+		baseURL: baseURL,
+		http:    &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// APIError is an error answered by the server. Message is meant to be shown
+// to the user; Fields holds per-field messages for rejected forms, and
+// RetryAfter how long to wait when the server throttled the request.
+type APIError struct {
+	Status     int
+	Message    string
+	Fields     map[string]string
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+// Register creates an account. email is optional and publicKey is the
+// user's message key.
+func (c *APIClient) Register(name, password, email string, publicKey []byte) error {
+	req := map[string]interface{}{"name": name, "password": password, "email": email, "public_key": publicKey}
+	return c.do("POST", "/register", "", req, nil)
+}
+
+// RequestReset asks the server to mail a password reset code to the
+// account's email address.
+func (c *APIClient) RequestReset(name string) error {
+	return c.do("POST", "/password/reset-request", "", map[string]string{"name": name}, nil)
+}
+
+// ResetPassword sets a new password using a mailed reset code. Every session
+// of the account ends.
+func (c *APIClient) ResetPassword(token, password string) error {
+	req := map[string]string{"token": token, "password": password}
+	return c.do("POST", "/password/reset", "", req, nil)
+}
+
+// LoginResult is the server's answer to a correct password: either a session
+// token, or a challenge to complete with LoginTOTP when the account has
+// two-factor authentication enabled.
+type LoginResult struct {
+	Token        string `json:"token"`
+	Role         string `json:"role"`
+	TOTPRequired bool   `json:"totp_required"`
+	Challenge    string `json:"challenge"`
+}
+
+func (c *APIClient) Login(name, password string) (LoginResult, error) {
+	req := map[string]string{"name": name, "password": password}
+
+	var res LoginResult
+	err := c.do("POST", "/login", "", req, &res)
+	return res, err
+}
+
+// LoginTOTP finishes a two-factor login with a TOTP or recovery code.
+func (c *APIClient) LoginTOTP(challenge, code string) (LoginResult, error) {
+	req := map[string]string{"challenge": challenge, "code": code}
+
+	var res LoginResult
+	err := c.do("POST", "/login/totp", "", req, &res)
+	return res, err
+}
+
+func (c *APIClient) TOTPEnabled(token string) (bool, error) {
+	var resp struct {
+		Enabled bool `json:"enabled"`
+	}
+	err := c.do("GET", "/totp", token, nil, &resp)
+	return resp.Enabled, err
+}
+
+// TOTPSetup starts enrolment and returns the new secret and its otpauth URI.
+func (c *APIClient) TOTPSetup(token string) (secret, uri string, err error) {
+	var resp struct {
+		Secret string `json:"secret"`
+		URI    string `json:"uri"`
+	}
+	err = c.do("POST", "/totp/setup", token, nil, &resp)
+	return resp.Secret, resp.URI, err
+}
+
+// TOTPEnable confirms enrolment with a code from the authenticator app and
+// returns the recovery codes.
+func (c *APIClient) TOTPEnable(token, code string) ([]string, error) {
+	var resp struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	err := c.do("POST", "/totp/enable", token, map[string]string{"code": code}, &resp)
+	return resp.RecoveryCodes, err
+}
+
+func (c *APIClient) TOTPDisable(token, code string) error {
+	return c.do("POST", "/totp/disable", token, map[string]string{"code": code}, nil)
+}
+
+// Server returns the URL of the server the client talks to.
+func (c *APIClient) Server() string {
+	return c.baseURL
+}
+
+// Me returns the name and role of the session's user. It fails with a 401
+// APIError once the session has expired.
+func (c *APIClient) Me(token string) (name, role string, err error) {
+	var resp struct {
+		Name string `json:"name"`
+		Role string `json:"role"`
+	}
+	err = c.do("GET", "/me", token, nil, &resp)
+	return resp.Name, resp.Role, err
+}
+
+func (c *APIClient) Logout(token string) error {
+	return c.do("POST", "/logout", token, nil, nil)
+}
+
+type Category struct {
+	ID          int64  `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type Thread struct {
+	ID       int64     `json:"id"`
+	Category int64     `json:"category"`
+	Title    string    `json:"title"`
+	Author   string    `json:"author"`
+	Created  time.Time `json:"created"`
+	Updated  time.Time `json:"updated"`
+	Replies  int       `json:"replies"`
+	Hidden   bool      `json:"hidden"`
+	Locked   bool      `json:"locked"`
+}
+
+type Post struct {
+	ID      int64     `json:"id"`
+	Thread  int64     `json:"thread"`
+	Author  string    `json:"author"`
+	Body    string    `json:"body"`
+	Created time.Time `json:"created"`
+	Hidden  bool      `json:"hidden"`
+}
+
+// Page describes which slice of a list the server answered with.
+type Page struct {
+	Page    int `json:"page"`
+	PerPage int `json:"per_page"`
+	Total   int `json:"total"`
+}
+
+func (p Page) HasPrev() bool { return p.Page > 1 }
+func (p Page) HasNext() bool { return p.Page*p.PerPage < p.Total }
+func (p Page) Prev() int     { return p.Page - 1 }
+func (p Page) Next() int     { return p.Page + 1 }
+
+func (p Page) Last() int {
+	if p.Total == 0 || p.PerPage == 0 {
+		return 1
+	}
+	return (p.Total + p.PerPage - 1) / p.PerPage
+}
+
+// lastPage asks for a page past the end of any list; views then fall back to
+// the real last page using the total in the answer.
+const lastPage = 1 << 30
+
+func (c *APIClient) Categories(token string) ([]Category, error) {
+	var resp struct {
+		Categories []Category `json:"categories"`
+	}
+	err := c.do("GET", "/categories", token, nil, &resp)
+	return resp.Categories, err
+}
+
+func (c *APIClient) Threads(token string, category int64, page int) ([]Thread, Page, error) {
+	var resp struct {
+		Threads []Thread `json:"threads"`
+		Page    Page     `json:"page"`
+	}
+	path := "/categories/" + strconv.FormatInt(category, 10) + "/threads?page=" + strconv.Itoa(page)
+	err := c.do("GET", path, token, nil, &resp)
+	return resp.Threads, resp.Page, err
+}
+
+func (c *APIClient) CreateThread(token string, category int64, title, body string) (Thread, error) {
+	req := map[string]interface{}{"category": category, "title": title, "body": body}
+
+	var t Thread
+	err := c.do("POST", "/threads", token, req, &t)
+	return t, err
+}
+
+// Thread returns a thread together with a page of its posts.
+func (c *APIClient) Thread(token string, id int64, page int) (Thread, []Post, Page, error) {
+	var resp struct {
+		Thread Thread `json:"thread"`
+		Posts  []Post `json:"posts"`
+		Page   Page   `json:"page"`
+	}
+	path := "/threads/" + strconv.FormatInt(id, 10) + "?page=" + strconv.Itoa(page)
+	err := c.do("GET", path, token, nil, &resp)
+	return resp.Thread, resp.Posts, resp.Page, err
+}
+
+func (c *APIClient) Reply(token string, thread int64, body string) error {
+	req := map[string]string{"body": body}
+	return c.do("POST", "/threads/"+strconv.FormatInt(thread, 10)+"/posts", token, req, nil)
+}
+
+// Message is a private message as stored by the server: Box can only be
+// opened with the private key of one of the two users.
+type Message struct {
+	ID           int64     `json:"id"`
+	From         string    `json:"from"`
+	To           string    `json:"to"`
+	SenderKey    []byte    `json:"sender_key"`
+	RecipientKey []byte    `json:"recipient_key"`
+	Nonce        []byte    `json:"nonce"`
+	Box          []byte    `json:"box"`
+	Sent         time.Time `json:"sent"`
+}
+
+type Conversation struct {
+	Peer     string    `json:"peer"`
+	Messages int       `json:"messages"`
+	Last     time.Time `json:"last"`
+}
+
+// SetKey publishes a new message key for the current user.
+func (c *APIClient) SetKey(token string, publicKey []byte) error {
+	req := map[string][]byte{"public_key": publicKey}
+	return c.do("PUT", "/keys", token, req, nil)
+}
+
+func (c *APIClient) UserKey(token, name string) ([]byte, error) {
+	var resp struct {
+		PublicKey []byte `json:"public_key"`
+	}
+	err := c.do("GET", "/users/"+url.PathEscape(name)+"/key", token, nil, &resp)
+	return resp.PublicKey, err
+}
+
+func (c *APIClient) SendMessage(token string, m Message) error {
+	req := map[string]interface{}{
+		"to":            m.To,
+		"sender_key":    m.SenderKey,
+		"recipient_key": m.RecipientKey,
+		"nonce":         m.Nonce,
+		"box":           m.Box,
+	}
+	return c.do("POST", "/messages", token, req, nil)
+}
+
+func (c *APIClient) Conversations(token string) ([]Conversation, error) {
+	var resp struct {
+		Conversations []Conversation `json:"conversations"`
+	}
+	err := c.do("GET", "/messages", token, nil, &resp)
+	return resp.Conversations, err
+}
+
+func (c *APIClient) Conversation(token, peer string, page int) ([]Message, Page, error) {
+	var resp struct {
+		Messages []Message `json:"messages"`
+		Page     Page      `json:"page"`
+	}
+	err := c.do("GET", "/messages/"+url.PathEscape(peer)+"?page="+strconv.Itoa(page), token, nil, &resp)
+	return resp.Messages, resp.Page, err
+}
+
+// ReportItem is a reported post waiting in the moderation queue.
+type ReportItem struct {
+	ID       int64     `json:"id"`
+	Post     int64     `json:"post"`
+	Thread   int64     `json:"thread"`
+	Title    string    `json:"title"`
+	Author   string    `json:"author"`
+	Body     string    `json:"body"`
+	Reporter string    `json:"reporter"`
+	Reason   string    `json:"reason"`
+	Created  time.Time `json:"created"`
+}
+
+// Account is a user as listed to admins.
+type Account struct {
+	Name    string    `json:"name"`
+	Role    string    `json:"role"`
+	Banned  bool      `json:"banned"`
+	Created time.Time `json:"created"`
+}
+
+func (c *APIClient) ReportPost(token string, post int64, reason string) error {
+	path := "/posts/" + strconv.FormatInt(post, 10) + "/report"
+	return c.do("POST", path, token, map[string]string{"reason": reason}, nil)
+}
+
+// ModerateThread applies a moderator action ("hide", "lock" or "move") to a
+// thread; req holds its value, e.g. {"locked": true}.
+func (c *APIClient) ModerateThread(token string, thread int64, action string, req map[string]interface{}) error {
+	path := "/mod/threads/" + strconv.FormatInt(thread, 10) + "/" + action
+	return c.do("POST", path, token, req, nil)
+}
+
+func (c *APIClient) Ban(token, name string, banned bool) error {
+	path := "/mod/users/" + url.PathEscape(name) + "/ban"
+	return c.do("POST", path, token, map[string]bool{"banned": banned}, nil)
+}
+
+func (c *APIClient) Reports(token string) ([]ReportItem, error) {
+	var resp struct {
+		Reports []ReportItem `json:"reports"`
+	}
+	err := c.do("GET", "/mod/reports", token, nil, &resp)
+	return resp.Reports, err
+}
+
+// ResolveReport closes a report, either with "dismiss" or "hide_post".
+func (c *APIClient) ResolveReport(token string, report int64, action string) error {
+	path := "/mod/reports/" + strconv.FormatInt(report, 10) + "/resolve"
+	return c.do("POST", path, token, map[string]string{"action": action}, nil)
+}
+
+func (c *APIClient) Accounts(token string) ([]Account, error) {
+	var resp struct {
+		Users []Account `json:"users"`
+	}
+	err := c.do("GET", "/admin/users", token, nil, &resp)
+	return resp.Users, err
+}
+
+func (c *APIClient) SetRole(token, name, role string) error {
+	path := "/admin/users/" + url.PathEscape(name) + "/role"
+	return c.do("POST", path, token, map[string]string{"role": role}, nil)
+}
+
+// Notification is a reply, mention or private message pushed by the server.
+type Notification struct {
+	Type    string    `json:"type"`
+	From    string    `json:"from"`
+	Thread  int64     `json:"thread"`
+	Post    int64     `json:"post"`
+	Title   string    `json:"title"`
+	Created time.Time `json:"created"`
+}
+
+// Events subscribes to the user's notifications and calls handle with each
+// one until ctx is done or the stream breaks. The stream has no timeout, so it
+// does not use c.http.
+func (c *APIClient) Events(ctx context.Context, token string, handle func(Notification)) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.baseURL+"/events", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("cannot reach the server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return apiError(resp)
+	}
+
+	// Server-sent events are blocks of "field: value" lines ended by a blank
+	// line. Only the data matters here; the type is in the JSON too.
+	var data strings.Builder
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			var n Notification
+			if data.Len() > 0 && json.Unmarshal([]byte(data.String()), &n) == nil {
+				handle(n)
+			}
+			data.Reset()
+		case strings.HasPrefix(line, "data:"):
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return errors.New("the server closed the event stream")
+}
+
+// do sends in as JSON, authenticated with token when it isn't empty, and
+// decodes the JSON response into out when it isn't nil.
+func (c *APIClient) do(method, path, token string, in, out interface{}) error {
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, c.baseURL+path, &body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("cannot reach the server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return apiError(resp)
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// apiError reads the error the server answered with.
+func apiError(resp *http.Response) *APIError {
+	var e struct {
+		Error  string            `json:"error"`
+		Fields map[string]string `json:"fields"`
+	}
+	if json.NewDecoder(resp.Body).Decode(&e) != nil || e.Error == "" {
+		e.Error = resp.Status
+	}
+
+	apiErr := &APIError{Status: resp.StatusCode, Message: e.Error, Fields: e.Fields}
+	if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		apiErr.RetryAfter = time.Duration(secs) * time.Second
+	}
+	return apiErr
+}
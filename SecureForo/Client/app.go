@@ -0,0 +1,119 @@
+package main
+
+import "net/http"
+
+// App ties the window, the views, the server API and the session together.
+// Every function the pages call is bound once, in Bind; the handlers read
+// their state from the session instead of from closures of the previous view.
+type App struct {
+	ui      UI
+	router  *Router
+	api     *APIClient
+	session *Session
+	notes   *Notifications
+}
+
+func NewApp(ui UI, api *APIClient) (*App, error) {
+	router, err := NewRouter(ui)
+	if err != nil {
+		return nil, err
+	}
+	return &App{ui: ui, router: router, api: api, session: &Session{}, notes: &Notifications{}}, nil
+}
+
+func (a *App) Bind() error {
+	bindings := map[string]interface{}{
+		"navigateFunc":         a.navigate,
+		"registerFunc":         a.register,
+		"passwordStrengthFunc": passwordStrength,
+		"loginFunc":            a.login,
+		"verifyTOTPFunc":       a.verifyTOTP,
+		"requestResetFunc":     a.requestReset,
+		"resetPasswordFunc":    a.resetPassword,
+		"logoutFunc":           a.logout,
+		"unlockFunc":           a.unlock,
+		"forgetFunc":           a.forget,
+
+		"forumFunc":        a.showForum,
+		"createThreadFunc": a.createThread,
+		"threadFunc":       a.showThread,
+		"replyFunc":        a.reply,
+
+		"inboxFunc":        a.showInbox,
+		"conversationFunc": a.showConversation,
+		"sendMessageFunc":  a.sendMessage,
+
+		"reportPostFunc":    a.reportPost,
+		"hideThreadFunc":    a.hideThread,
+		"lockThreadFunc":    a.lockThread,
+		"moveThreadFunc":    a.moveThread,
+		"adminFunc":         a.showAdmin,
+		"resolveReportFunc": a.resolveReport,
+		"banFunc":           a.ban,
+		"setRoleFunc":       a.setRole,
+
+		"notificationsFunc":      a.showNotifications,
+		"openNotificationFunc":   a.openNotification,
+		"clearNotificationsFunc": a.clearNotifications,
+
+		"securityFunc":    a.showSecurity,
+		"setupTOTPFunc":   a.setupTOTP,
+		"enableTOTPFunc":  a.enableTOTP,
+		"disableTOTPFunc": a.disableTOTP,
+	}
+
+	for name, f := range bindings {
+		if err := a.ui.Bind(name, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *App) Close() error {
+	a.notes.Stop()
+	return a.router.Close()
+}
+
+// publicViews can be opened with navigateFunc; every other view needs data
+// and has its own binding.
+var publicViews = map[string]bool{"login": true, "register": true, "reset_request": true, "reset": true}
+
+func (a *App) navigate(view string) {
+	if !publicViews[view] {
+		a.router.Message("error", "Unknown view "+view)
+		return
+	}
+	a.show(view, nil)
+}
+
+// show navigates to view, reporting failures in the current one. The unread
+// counter is carried over to the new view.
+func (a *App) show(view string, data interface{}) {
+	if err := a.router.Navigate(view, data); err != nil {
+		a.router.Message("error", err.Error())
+		return
+	}
+	a.router.Unread(a.notes.Count())
+}
+
+// endSession forgets the session and its notifications.
+func (a *App) endSession() {
+	a.notes.Stop()
+	a.session.Clear()
+}
+
+// fail reports err in the current view. A rejected session sends the user
+// back to the login view, and throttled requests show a countdown.
+func (a *App) fail(err error) {
+	apiErr, ok := err.(*APIError)
+	switch {
+	case ok && apiErr.Status == http.StatusUnauthorized && a.session.LoggedIn():
+		a.endSession()
+		a.show("login", nil)
+	case ok && apiErr.Status == http.StatusTooManyRequests && apiErr.RetryAfter > 0:
+		a.router.RetryCountdown(apiErr.RetryAfter)
+		return
+	}
+	a.router.Message("error", err.Error())
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeServer implements the part of the SecureForo API used by the register,
+// login and forum views.
+type fakeServer struct {
+	url string
+
+	mu        sync.Mutex
+	passwords map[string]string
+	keys      map[string][]byte
+
+	// events are streamed to whoever listens on /events.
+	events chan Notification
+}
+
+func (s *fakeServer) handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("POST /register", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Name      string `json:"name"`
+			Password  string `json:"password"`
+			Email     string `json:"email"`
+			PublicKey []byte `json:"public_key"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if _, ok := s.passwords[req.Name]; ok {
+			reply(w, http.StatusConflict, map[string]interface{}{
+				"error":  "Please correct the highlighted fields.",
+				"fields": map[string]string{"name": "This name is already taken."},
+			})
+			return
+		}
+		s.passwords[req.Name], s.keys[req.Name] = req.Password, req.PublicKey
+		reply(w, http.StatusCreated, map[string]string{"name": req.Name})
+	})
+
+	mux.HandleFunc("POST /login", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Name     string `json:"name"`
+			Password string `json:"password"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if pw, ok := s.passwords[req.Name]; !ok || pw != req.Password {
+			reply(w, http.StatusUnauthorized, map[string]string{"error": "invalid name or password"})
+			return
+		}
+		reply(w, http.StatusOK, map[string]string{"name": req.Name, "role": "user", "token": "token-" + req.Name})
+	})
+
+	mux.HandleFunc("POST /logout", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("PUT /keys", s.authorized(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	mux.HandleFunc("GET /me", s.authorized(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer token-")
+		reply(w, http.StatusOK, map[string]string{"name": name, "role": "user"})
+	}))
+
+	mux.HandleFunc("GET /events", s.authorized(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case n := <-s.events:
+				buf, _ := json.Marshal(n)
+				fmt.Fprintf(w, "event: %s\ndata: %s\n\n", n.Type, buf)
+				w.(http.Flusher).Flush()
+			}
+		}
+	}))
+
+	mux.HandleFunc("GET /categories", s.authorized(func(w http.ResponseWriter, r *http.Request) {
+		reply(w, http.StatusOK, map[string]interface{}{"categories": []Category{
+			{ID: 1, Name: "General", Description: "Anything about SecureForo"},
+			{ID: 2, Name: "Security", Description: "Cryptography, privacy and keeping safe online"},
+		}})
+	}))
+
+	mux.HandleFunc("GET /categories/{id}/threads", s.authorized(func(w http.ResponseWriter, r *http.Request) {
+		reply(w, http.StatusOK, map[string]interface{}{
+			"threads": []Thread{},
+			"page":    Page{Page: 1, PerPage: 20},
+		})
+	}))
+
+	return mux
+}
+
+func (s *fakeServer) authorized(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		_, ok := s.passwords[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer token-")]
+		s.mu.Unlock()
+
+		if !ok {
+			reply(w, http.StatusUnauthorized, map[string]string{"error": "invalid or expired session"})
+			return
+		}
+		next(w, r)
+	}
+}
+
+func reply(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// newTestApp starts a fake server and an App in a fake window. Message keys
+// and the vault are kept in a temporary directory.
+func newTestApp(t *testing.T) (*App, *fakeUI, *fakeServer) {
+	t.Helper()
+
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv("AppData", dir)
+
+	server := &fakeServer{
+		passwords: map[string]string{},
+		keys:      map[string][]byte{},
+		events:    make(chan Notification),
+	}
+	ts := httptest.NewServer(server.handler())
+	t.Cleanup(ts.Close)
+	server.url = ts.URL
+
+	app, ui := startTestApp(t, server)
+	return app, ui, server
+}
+
+// startTestApp starts the client against server, as if launched again.
+func startTestApp(t *testing.T, server *fakeServer) (*App, *fakeUI) {
+	t.Helper()
+
+	ui := newFakeUI()
+	app, err := NewApp(ui, NewAPIClient(server.url))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { app.Close() })
+
+	if err := app.Bind(); err != nil {
+		t.Fatal(err)
+	}
+	app.start()
+
+	return app, ui
+}
+
+const testPassword = "purple tiger galaxy 9z"
+
+func TestRegister(t *testing.T) {
+	app, ui, server := newTestApp(t)
+
+	ui.call(t, "navigateFunc", "register")
+	if view := app.router.View(); view != "register" {
+		t.Fatalf("view = %q, want register", view)
+	}
+
+	ui.call(t, "registerFunc", "alice", testPassword, testPassword, "alice@example.org")
+	if kind, text := ui.Message(); kind != "success" {
+		t.Fatalf("message = %s %q, want success", kind, text)
+	}
+	if len(server.keys["alice"]) != 32 {
+		t.Errorf("server got a %d byte public key, want 32", len(server.keys["alice"]))
+	}
+
+	keys, err := LoadKeys("alice")
+	if err != nil {
+		t.Fatalf("loading the saved keys: %v", err)
+	}
+	if string(keys.Public[:]) != string(server.keys["alice"]) {
+		t.Error("saved keys do not match the published public key")
+	}
+}
+
+func TestRegisterInvalid(t *testing.T) {
+	_, ui, server := newTestApp(t)
+
+	ui.call(t, "registerFunc", "a", "short", "short", "not an email")
+	if kind, _ := ui.Message(); kind != "error" {
+		t.Errorf("message kind = %q, want error", kind)
+	}
+	errs := ui.FieldErrors()
+	for _, field := range []string{"name", "password", "email"} {
+		if errs[field] == "" {
+			t.Errorf("no error shown for %s", field)
+		}
+	}
+	if len(server.passwords) != 0 {
+		t.Error("invalid registration reached the server")
+	}
+}
+
+func TestRegisterMismatch(t *testing.T) {
+	_, ui, server := newTestApp(t)
+
+	ui.call(t, "registerFunc", "alice", testPassword, "other", "")
+	if ui.FieldErrors()["confirm"] == "" {
+		t.Error("no error shown for confirm")
+	}
+	if len(server.passwords) != 0 {
+		t.Error("registration with a mismatched confirmation reached the server")
+	}
+}
+
+func TestRegisterTaken(t *testing.T) {
+	_, ui, _ := newTestApp(t)
+
+	ui.call(t, "registerFunc", "alice", testPassword, testPassword, "")
+	ui.call(t, "registerFunc", "alice", testPassword, testPassword, "")
+
+	if kind, _ := ui.Message(); kind != "error" {
+		t.Errorf("message kind = %q, want error", kind)
+	}
+	if ui.FieldErrors()["name"] == "" {
+		t.Error("no error shown for the taken name")
+	}
+}
+
+func TestLogin(t *testing.T) {
+	app, ui, _ := newTestApp(t)
+
+	ui.call(t, "registerFunc", "alice", testPassword, testPassword, "")
+	ui.call(t, "loginFunc", "alice", "wrong password", false, "")
+	if kind, text := ui.Message(); kind != "error" || !strings.Contains(text, "invalid name or password") {
+		t.Errorf("message = %s %q, want the server's error", kind, text)
+	}
+	if app.session.LoggedIn() {
+		t.Fatal("logged in with a wrong password")
+	}
+
+	ui.call(t, "loginFunc", "alice", testPassword, false, "")
+	if view := app.router.View(); view != "forum" {
+		t.Fatalf("view = %q, want forum", view)
+	}
+	if !strings.Contains(ui.Page(), "Logged in as alice") {
+		t.Error("forum does not show the user")
+	}
+	if s := app.session.State(); s.Name != "alice" || s.Token != "token-alice" || s.Keys == nil {
+		t.Errorf("session = %+v", s)
+	}
+}
+
+func TestNavigation(t *testing.T) {
+	app, ui, _ := newTestApp(t)
+
+	for _, view := range []string{"register", "reset_request", "login"} {
+		ui.call(t, "navigateFunc", view)
+		if got := app.router.View(); got != view {
+			t.Errorf("navigating to %s shows %s", view, got)
+		}
+	}
+
+	ui.call(t, "navigateFunc", "forum")
+	if got := app.router.View(); got != "login" {
+		t.Errorf("navigateFunc opened %s without a session", got)
+	}
+
+	ui.call(t, "registerFunc", "alice", testPassword, testPassword, "")
+	ui.call(t, "loginFunc", "alice", testPassword, false, "")
+	ui.call(t, "forumFunc", 2, 1)
+	if !strings.Contains(ui.Page(), "Cryptography, privacy") {
+		t.Error("forumFunc did not open the Security category")
+	}
+
+	ui.call(t, "logoutFunc")
+	if got := app.router.View(); got != "login" || app.session.LoggedIn() {
+		t.Errorf("after logout: view %s, logged in %v", got, app.session.LoggedIn())
+	}
+}
+
+func TestRememberMe(t *testing.T) {
+	app, ui, server := newTestApp(t)
+
+	ui.call(t, "registerFunc", "alice", testPassword, testPassword, "")
+	ui.call(t, "loginFunc", "alice", testPassword, true, "short")
+	if ui.FieldErrors()["passphrase"] == "" || app.session.LoggedIn() {
+		t.Fatal("a short passphrase was accepted")
+	}
+
+	ui.call(t, "loginFunc", "alice", testPassword, true, "open sesame")
+	if !app.session.LoggedIn() || !HasVault() {
+		t.Fatal("session was not remembered")
+	}
+
+	app, ui = startTestApp(t, server)
+	if view := app.router.View(); view != "unlock" {
+		t.Fatalf("view on start = %q, want unlock", view)
+	}
+
+	ui.call(t, "unlockFunc", "open says me")
+	if kind, _ := ui.Message(); kind != "error" || app.session.LoggedIn() {
+		t.Fatal("unlocked with a wrong passphrase")
+	}
+
+	ui.call(t, "unlockFunc", "open sesame")
+	if view := app.router.View(); view != "forum" {
+		t.Fatalf("view after unlock = %q, want forum", view)
+	}
+	if s := app.session.State(); s.Name != "alice" || s.Token != "token-alice" || s.Keys == nil {
+		t.Errorf("session = %+v", s)
+	}
+	if !HasVault() {
+		t.Fatal("unlocking forgot the session")
+	}
+
+	// The session can be resumed again, not just once.
+	app, ui = startTestApp(t, server)
+	ui.call(t, "unlockFunc", "open sesame")
+	if view := app.router.View(); view != "forum" || !HasVault() {
+		t.Fatalf("second unlock: view = %q, remembered = %v, want forum and true", view, HasVault())
+	}
+
+	ui.call(t, "logoutFunc")
+	if HasVault() {
+		t.Error("logout did not forget the session")
+	}
+}
+
+// TestRememberMeOptOut checks that logging in without "remember me" forgets
+// a session remembered earlier.
+func TestRememberMeOptOut(t *testing.T) {
+	app, ui, _ := newTestApp(t)
+
+	ui.call(t, "registerFunc", "alice", testPassword, testPassword, "")
+	ui.call(t, "loginFunc", "alice", testPassword, true, "open sesame")
+	if !HasVault() {
+		t.Fatal("session was not remembered")
+	}
+	app.endSession()
+
+	ui.call(t, "loginFunc", "alice", testPassword, false, "")
+	if !app.session.LoggedIn() {
+		t.Fatal("login failed")
+	}
+	if HasVault() {
+		t.Error("the session remembered earlier was kept")
+	}
+}
+
+func TestRememberMeExpired(t *testing.T) {
+	_, ui, server := newTestApp(t)
+
+	ui.call(t, "registerFunc", "alice", testPassword, testPassword, "")
+	ui.call(t, "loginFunc", "alice", testPassword, true, "open sesame")
+
+	server.mu.Lock()
+	delete(server.passwords, "alice")
+	server.mu.Unlock()
+
+	app, ui := startTestApp(t, server)
+	ui.call(t, "unlockFunc", "open sesame")
+	if view := app.router.View(); view != "login" || app.session.LoggedIn() {
+		t.Fatalf("expired session resumed: view %q", view)
+	}
+	if HasVault() {
+		t.Error("expired session was not forgotten")
+	}
+}
+
+func TestNotifications(t *testing.T) {
+	app, ui, server := newTestApp(t)
+
+	ui.call(t, "registerFunc", "alice", testPassword, testPassword, "")
+	ui.call(t, "loginFunc", "alice", testPassword, false, "")
+
+	n := Notification{Type: "reply", From: "bob", Thread: 7, Post: 8, Title: "Hello", Created: time.Now()}
+	select {
+	case server.events <- n:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the client did not subscribe to events")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !ui.Evaluated("setUnread(1)") {
+		if time.Now().After(deadline) {
+			t.Fatal("the unread counter was not updated")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !ui.Evaluated(`showToast({"from":"bob","text":"bob replied in “Hello”","thread":7})`) {
+		t.Error("no toast for the reply")
+	}
+
+	ui.call(t, "notificationsFunc")
+	if !strings.Contains(ui.Page(), "bob replied in “Hello”") {
+		t.Error("notifications view does not list the reply")
+	}
+
+	ui.call(t, "clearNotificationsFunc")
+	if app.notes.Count() != 0 || !strings.Contains(ui.Page(), "No unread notifications.") {
+		t.Error("notifications were not marked as read")
+	}
+}
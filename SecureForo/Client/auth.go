@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+func (a *App) register(name, password, confirm, email string) {
+	errs := validateRegistration(name, password, confirm, email)
+	a.router.FieldErrors(errs)
+	if len(errs) > 0 {
+		a.router.Message("error", "Please correct the highlighted fields.")
+		return
+	}
+
+	keys, err := GenerateKeys()
+	if err != nil {
+		a.fail(err)
+		return
+	}
+
+	if err := a.api.Register(name, password, email, keys.Public[:]); err != nil {
+		var apiErr *APIError
+		if errors.As(err, &apiErr) {
+			a.router.FieldErrors(apiErr.Fields)
+		}
+		a.fail(err)
+		return
+	}
+
+	if err := keys.Save(name); err != nil {
+		a.router.Message("error", "Account created, but the message keys could not be saved: "+err.Error())
+		return
+	}
+	a.router.Message("success", "Account created, you can login now.")
+}
+
+// login checks the password and, for accounts with two-factor
+// authentication, asks for the code. With remember set the session token is
+// kept in the vault, sealed with passphrase.
+func (a *App) login(name, password string, remember bool, passphrase string) {
+	if !remember {
+		passphrase = ""
+	}
+	errs := map[string]string{}
+	if remember && len(passphrase) < minPassphraseLen {
+		errs["passphrase"] = "Passphrase must be at least " + strconv.Itoa(minPassphraseLen) + " characters."
+	}
+	a.router.FieldErrors(errs)
+	if len(errs) > 0 {
+		a.router.Message("error", "Please correct the highlighted fields.")
+		return
+	}
+
+	res, err := a.api.Login(name, password)
+	if err != nil {
+		a.fail(err)
+		return
+	}
+
+	if res.TOTPRequired {
+		a.session.SetChallenge(name, res.Challenge, passphrase)
+		a.show("totp_login", nil)
+		return
+	}
+	a.finishLogin(name, res, passphrase)
+}
+
+func (a *App) verifyTOTP(code string) {
+	name, challenge, passphrase := a.session.Challenge()
+	if challenge == "" {
+		a.show("login", nil)
+		return
+	}
+
+	res, err := a.api.LoginTOTP(challenge, code)
+	if err != nil {
+		a.fail(err)
+		return
+	}
+	a.finishLogin(name, res, passphrase)
+}
+
+// finishLogin stores the session of a password login, remembers it if a
+// passphrase is given and forgets any session remembered earlier otherwise,
+// and opens the forum.
+func (a *App) finishLogin(name string, res LoginResult, passphrase string) {
+	if !a.openSession(name, res) {
+		return
+	}
+
+	var vaultErr string
+	if passphrase != "" {
+		if err := SaveVault(Remembered{Server: a.api.Server(), Name: name, Token: res.Token}, passphrase); err != nil {
+			vaultErr = "Cannot remember the session: " + err.Error()
+		}
+	} else if err := ForgetVault(); err != nil {
+		vaultErr = "Cannot forget the session remembered earlier: " + err.Error()
+	}
+	a.showForum(0, 1)
+
+	if vaultErr != "" {
+		a.router.Message("error", vaultErr)
+	}
+}
+
+// openSession stores a session and starts listening to its notifications.
+func (a *App) openSession(name string, res LoginResult) bool {
+	keys, err := loginKeys(a.api, name, res.Token)
+	if err != nil {
+		a.router.Message("error", "Cannot set up message keys: "+err.Error())
+		return false
+	}
+
+	a.session.LogIn(name, res.Role, res.Token, keys)
+	a.notes.Start(func(ctx context.Context) { a.listen(ctx, res.Token) })
+	return true
+}
+
+// start opens the unlock view when a session is remembered, and the login
+// view otherwise.
+func (a *App) start() {
+	if HasVault() {
+		a.show("unlock", nil)
+		return
+	}
+	a.show("login", nil)
+}
+
+// unlock resumes the session kept in the vault, which stays remembered. A
+// session that is no longer valid is forgotten.
+func (a *App) unlock(passphrase string) {
+	rem, err := OpenVault(passphrase)
+	if errors.Is(err, os.ErrNotExist) {
+		a.show("login", nil)
+		return
+	}
+	if err != nil {
+		a.router.Message("error", err.Error())
+		return
+	}
+
+	if rem.Server != a.api.Server() {
+		a.forget()
+		a.router.Message("error", "The remembered session belongs to another server, please login.")
+		return
+	}
+
+	_, role, err := a.api.Me(rem.Token)
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.Status == http.StatusUnauthorized {
+		a.forget()
+		a.router.Message("error", "Your session has expired, please login again.")
+		return
+	}
+	if err != nil {
+		a.fail(err)
+		return
+	}
+
+	if a.openSession(rem.Name, LoginResult{Token: rem.Token, Role: role}) {
+		a.showForum(0, 1)
+	}
+}
+
+// forget deletes the remembered session and shows the login view.
+func (a *App) forget() {
+	if err := ForgetVault(); err != nil {
+		a.router.Message("error", "Cannot forget the session: "+err.Error())
+		return
+	}
+	a.show("login", nil)
+}
+
+func (a *App) requestReset(name string) {
+	if err := a.api.RequestReset(name); err != nil {
+		a.fail(err)
+		return
+	}
+	a.show("reset", nil)
+	a.router.Message("success", "If the account has an email address, a reset code was sent to it.")
+}
+
+func (a *App) resetPassword(token, password, confirm string) {
+	errs := validateNewPassword("", password, confirm)
+	a.router.FieldErrors(errs)
+	if len(errs) > 0 {
+		a.router.Message("error", "Please correct the highlighted fields.")
+		return
+	}
+
+	if err := a.api.ResetPassword(token, password); err != nil {
+		var apiErr *APIError
+		if errors.As(err, &apiErr) {
+			a.router.FieldErrors(apiErr.Fields)
+		}
+		a.fail(err)
+		return
+	}
+
+	a.show("login", nil)
+	a.router.Message("success", "Your password was changed, you can login now.")
+}
+
+func (a *App) logout() {
+	a.api.Logout(a.session.State().Token)
+	a.endSession()
+	a.forget()
+}
+
+// loginKeys loads the user's message keys. On a machine that has none, a new
+// pair is generated and published; messages sealed for the old key can then
+// only be read where that key lives.
+func loginKeys(api *APIClient, name, token string) (*KeyPair, error) {
+	keys, err := LoadKeys(name)
+	if err == nil {
+		return keys, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	if keys, err = GenerateKeys(); err != nil {
+		return nil, err
+	}
+	if err := keys.Save(name); err != nil {
+		return nil, err
+	}
+	return keys, api.SetKey(token, keys.Public[:])
+}
@@ -0,0 +1,220 @@
+//go:build e2e
+
+package main
+
+// The end-to-end tests drive the real pages in headless Chrome against a real
+// server. Run them with
+//
+//	go test -tags e2e
+//
+// They build and start ../Server, unless SECUREFORO_SERVER points to a
+// running one, and are skipped when Chrome is not installed.
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zserge/lorca"
+)
+
+const e2eTimeout = 10 * time.Second
+
+// startServer returns the URL of a SecureForo server for the test.
+func startServer(t *testing.T) string {
+	t.Helper()
+
+	if url := os.Getenv("SECUREFORO_SERVER"); url != "" {
+		return url
+	}
+
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "server")
+	if out, err := exec.Command("go", "build", "-o", bin, "../Server").CombinedOutput(); err != nil {
+		t.Fatalf("building the server: %v\n%s", err, out)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	cmd := exec.Command(bin,
+		"-addr", addr,
+		"-data", filepath.Join(dir, "secureforo.json"),
+		"-audit", filepath.Join(dir, "audit.log"),
+		"-mail-dir", dir,
+	)
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	url := "http://" + addr
+	waitFor(t, "the server to start", func() bool {
+		resp, err := http.Get(url + "/categories")
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return true
+	})
+	return url
+}
+
+// newBrowserApp opens the client in headless Chrome, showing the login view.
+func newBrowserApp(t *testing.T) (*App, lorca.UI) {
+	t.Helper()
+
+	if lorca.LocateChrome() == "" {
+		t.Skip("Chrome is not installed")
+	}
+
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv("AppData", dir)
+
+	server := startServer(t)
+
+	ui, err := lorca.New("", filepath.Join(dir, "chrome"), 800, 600, "--headless")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ui.Close() })
+
+	app, err := NewApp(ui, NewAPIClient(server))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { app.Close() })
+
+	if err := app.Bind(); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.router.Navigate("login", nil); err != nil {
+		t.Fatal(err)
+	}
+	return app, ui
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(e2eTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func waitForView(t *testing.T, app *App, ui lorca.UI, view string) {
+	t.Helper()
+
+	waitFor(t, "the "+view+" view", func() bool {
+		return app.router.View() == view &&
+			ui.Eval(`document.readyState === 'complete'`).Bool()
+	})
+}
+
+// fill sets the value of the form fields by id.
+func fill(ui lorca.UI, fields map[string]string) {
+	for id, value := range fields {
+		ui.Eval("document.getElementById(" + jsString(id) + ").value = " + jsString(value))
+	}
+}
+
+// click clicks the first element matching selector.
+func click(ui lorca.UI, selector string) {
+	ui.Eval("document.querySelector(" + jsString(selector) + ").click()")
+}
+
+func message(ui lorca.UI) (kind, text string) {
+	return ui.Eval(`document.getElementById('message').className`).String(),
+		ui.Eval(`document.getElementById('message').textContent`).String()
+}
+
+func waitForMessage(t *testing.T, ui lorca.UI) (kind, text string) {
+	t.Helper()
+
+	waitFor(t, "a message", func() bool {
+		kind, _ = message(ui)
+		return kind != ""
+	})
+	return message(ui)
+}
+
+// uniqueName returns a user name that is not taken, even on a shared server.
+func uniqueName(t *testing.T) string {
+	buf := make([]byte, 4)
+	if _, err := rand.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	return "e2e" + hex.EncodeToString(buf)
+}
+
+func TestBrowserRegisterLoginNavigate(t *testing.T) {
+	app, ui := newBrowserApp(t)
+	name := uniqueName(t)
+
+	click(ui, `button[onclick*="'register'"]`)
+	waitForView(t, app, ui, "register")
+
+	fill(ui, map[string]string{"name": "x", "password": "short", "confirm": "other", "email": "nope"})
+	click(ui, `input[type=submit]`)
+	if kind, _ := waitForMessage(t, ui); kind != "error" {
+		t.Fatalf("invalid registration: message kind %q, want error", kind)
+	}
+	if text := ui.Eval(`document.querySelector('[data-error-for=password]').textContent`).String(); text == "" {
+		t.Error("no inline error for the password")
+	}
+
+	ui.Eval(`showMessage('', '')`)
+	fill(ui, map[string]string{"name": name, "password": testPassword, "confirm": testPassword, "email": ""})
+	click(ui, `input[type=submit]`)
+	if kind, text := waitForMessage(t, ui); kind != "success" {
+		t.Fatalf("registration: %s %q", kind, text)
+	}
+
+	click(ui, `button[onclick*="'login'"]`)
+	waitForView(t, app, ui, "login")
+
+	fill(ui, map[string]string{"name": name, "password": "wrong password"})
+	click(ui, `input[type=submit]`)
+	if kind, text := waitForMessage(t, ui); kind != "error" {
+		t.Fatalf("login with a wrong password: %s %q", kind, text)
+	}
+
+	fill(ui, map[string]string{"name": name, "password": testPassword})
+	click(ui, `input[type=submit]`)
+	waitForView(t, app, ui, "forum")
+	if body := ui.Eval(`document.body.textContent`).String(); !strings.Contains(body, "Logged in as "+name) {
+		t.Error("forum does not show the user")
+	}
+
+	click(ui, `nav button:not([disabled])`)
+	waitFor(t, "another category", func() bool {
+		return ui.Eval(`document.readyState === 'complete' && document.querySelector('h2').textContent !== 'General'`).Bool()
+	})
+
+	click(ui, `button[onclick="logoutFunc()"]`)
+	waitForView(t, app, ui, "login")
+	if app.session.LoggedIn() {
+		t.Error("still logged in after logout")
+	}
+}
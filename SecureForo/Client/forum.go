@@ -0,0 +1,90 @@
+package main
+
+// showForum lists a page of the threads in a category. A zero category shows
+// the first one.
+func (a *App) showForum(category int64, page int) {
+	s := a.session.State()
+
+	cats, err := a.api.Categories(s.Token)
+	if err != nil {
+		a.fail(err)
+		return
+	}
+	if len(cats) == 0 {
+		a.router.Message("error", "The forum has no categories.")
+		return
+	}
+
+	current := cats[0]
+	for _, c := range cats {
+		if c.ID == category {
+			current = c
+		}
+	}
+
+	threads, p, err := a.api.Threads(s.Token, current.ID, page)
+	if err != nil {
+		a.fail(err)
+		return
+	}
+
+	a.show("forum", map[string]interface{}{
+		"User":       s.Name,
+		"Moderator":  s.IsModerator(),
+		"Categories": cats,
+		"Category":   current,
+		"Threads":    threads,
+		"Page":       p,
+	})
+}
+
+func (a *App) createThread(category int64, title, body string) {
+	t, err := a.api.CreateThread(a.session.State().Token, category, title, body)
+	if err != nil {
+		a.fail(err)
+		return
+	}
+	a.showThread(t.ID, 1)
+}
+
+// showThread shows a page of the posts in a thread. A page past the end shows
+// the last one.
+func (a *App) showThread(id int64, page int) {
+	s := a.session.State()
+	token := s.Token
+
+	a.notes.MarkRead(func(n Notification) bool { return n.Thread == id })
+
+	t, posts, p, err := a.api.Thread(token, id, page)
+	if err == nil && page > 1 && len(posts) == 0 && p.Total > 0 {
+		t, posts, p, err = a.api.Thread(token, id, p.Last())
+	}
+	if err != nil {
+		a.fail(err)
+		return
+	}
+
+	data := map[string]interface{}{
+		"Thread":    t,
+		"Posts":     posts,
+		"Page":      p,
+		"Moderator": s.IsModerator(),
+	}
+	if s.IsModerator() {
+		if data["Categories"], err = a.api.Categories(token); err != nil {
+			a.fail(err)
+			return
+		}
+	}
+	a.show("thread", data)
+}
+
+func (a *App) reply(thread int64, body string) {
+	if err := a.api.Reply(a.session.State().Token, thread, body); err != nil {
+		a.fail(err)
+		return
+	}
+
+	// Jump to the last page, where the new reply is.
+	a.showThread(thread, lastPage)
+}
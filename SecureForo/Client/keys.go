@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+var errDecrypt = errors.New("message cannot be decrypted")
+
+// KeyPair is the user's X25519 key pair for private messages. The private key
+// only ever lives in this file on the user's machine; the server just gets the
+// public half.
+type KeyPair struct {
+	Public  [32]byte `json:"public"`
+	Private [32]byte `json:"private"`
+}
+
+func GenerateKeys() (*KeyPair, error) {
+	pub, priv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyPair{Public: *pub, Private: *priv}, nil
+}
+
+// dataDir is where the client keeps its local files.
+func dataDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "SecureForo"), nil
+}
+
+func keyFile(name string) (string, error) {
+	dir, err := dataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "keys", url.PathEscape(name)+".json"), nil
+}
+
+// LoadKeys reads the key pair saved for the named user. The error wraps
+// os.ErrNotExist when this machine has none.
+func LoadKeys(name string) (*KeyPair, error) {
+	path, err := keyFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	k := &KeyPair{}
+	return k, json.Unmarshal(buf, k)
+}
+
+// Save writes the key pair for the named user, readable only by the current
+// OS user.
+func (k *KeyPair) Save(name string) error {
+	path, err := keyFile(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	buf, err := json.Marshal(k)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf, 0600)
+}
+
+// Seal encrypts msg for the owner of peer.
+func (k *KeyPair) Seal(msg []byte, peer []byte) (nonce, sealed []byte, err error) {
+	peerKey, err := toKey(peer)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var n [24]byte
+	if _, err := rand.Read(n[:]); err != nil {
+		return nil, nil, err
+	}
+	return n[:], box.Seal(nil, msg, &n, peerKey, &k.Private), nil
+}
+
+// Open decrypts a message exchanged with the owner of peer.
+func (k *KeyPair) Open(sealed, nonce, peer []byte) ([]byte, error) {
+	peerKey, err := toKey(peer)
+	if err != nil || len(nonce) != 24 {
+		return nil, errDecrypt
+	}
+
+	var n [24]byte
+	copy(n[:], nonce)
+
+	msg, ok := box.Open(nil, sealed, &n, peerKey, &k.Private)
+	if !ok {
+		return nil, errDecrypt
+	}
+	return msg, nil
+}
+
+func toKey(b []byte) (*[32]byte, error) {
+	if len(b) != 32 {
+		return nil, errors.New("invalid public key")
+	}
+	var key [32]byte
+	copy(key[:], b)
+	return &key, nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"time"
+)
+
+// decryptedMessage is a Message opened with the user's keys, ready to render.
+type decryptedMessage struct {
+	From string
+	Sent time.Time
+	Text string
+	Err  error
+}
+
+// decrypt opens m with the user's private key. Messages sent by the user are
+// opened with the recipient's key, since NaCl box derives the same shared key
+// on both sides.
+func decrypt(name string, keys *KeyPair, m Message) decryptedMessage {
+	d := decryptedMessage{From: m.From, Sent: m.Sent}
+
+	mine, peer := m.RecipientKey, m.SenderKey
+	if m.From == name {
+		mine, peer = m.SenderKey, m.RecipientKey
+	}
+	if !bytes.Equal(mine, keys.Public[:]) {
+		d.Err = errDecrypt
+		return d
+	}
+
+	text, err := keys.Open(m.Box, m.Nonce, peer)
+	d.Text, d.Err = string(text), err
+	return d
+}
+
+// showInbox lists the user's conversations.
+func (a *App) showInbox() {
+	convs, err := a.api.Conversations(a.session.State().Token)
+	if err != nil {
+		a.fail(err)
+		return
+	}
+	a.show("inbox", convs)
+}
+
+// showConversation shows a page of the decrypted messages exchanged with
+// peer. A page past the end shows the last one.
+func (a *App) showConversation(peer string, page int) {
+	s := a.session.State()
+
+	a.notes.MarkRead(func(n Notification) bool { return n.Type == "message" && n.From == peer })
+
+	msgs, p, err := a.api.Conversation(s.Token, peer, page)
+	if err == nil && page > 1 && len(msgs) == 0 && p.Total > 0 {
+		msgs, p, err = a.api.Conversation(s.Token, peer, p.Last())
+	}
+	if err != nil {
+		a.fail(err)
+		return
+	}
+
+	decrypted := make([]decryptedMessage, len(msgs))
+	for i, m := range msgs {
+		decrypted[i] = decrypt(s.Name, s.Keys, m)
+	}
+
+	a.show("conversation", map[string]interface{}{
+		"Peer":     peer,
+		"Messages": decrypted,
+		"Page":     p,
+	})
+}
+
+// sendMessage seals text with the recipient's current public key, sends it
+// and opens the conversation.
+func (a *App) sendMessage(to, text string) {
+	s := a.session.State()
+
+	peer, err := a.api.UserKey(s.Token, to)
+	if err != nil {
+		a.fail(err)
+		return
+	}
+
+	nonce, sealed, err := s.Keys.Seal([]byte(text), peer)
+	if err != nil {
+		a.fail(err)
+		return
+	}
+
+	err = a.api.SendMessage(s.Token, Message{
+		To:           to,
+		SenderKey:    s.Keys.Public[:],
+		RecipientKey: peer,
+		Nonce:        nonce,
+		Box:          sealed,
+	})
+	if err != nil {
+		a.fail(err)
+		return
+	}
+	a.showConversation(to, lastPage)
+}
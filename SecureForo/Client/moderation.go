@@ -0,0 +1,91 @@
+package main
+
+import "strings"
+
+func (a *App) reportPost(post int64, reason string) {
+	if strings.TrimSpace(reason) == "" {
+		return
+	}
+
+	if err := a.api.ReportPost(a.session.State().Token, post, reason); err != nil {
+		a.fail(err)
+		return
+	}
+	a.router.Message("success", "Thanks, the moderators will look at this post.")
+}
+
+// moderateThread applies a moderator action and shows the thread again.
+func (a *App) moderateThread(thread int64, action string, req map[string]interface{}) {
+	if err := a.api.ModerateThread(a.session.State().Token, thread, action, req); err != nil {
+		a.fail(err)
+		return
+	}
+	a.showThread(thread, 1)
+}
+
+func (a *App) hideThread(thread int64, hidden bool) {
+	a.moderateThread(thread, "hide", map[string]interface{}{"hidden": hidden})
+}
+
+func (a *App) lockThread(thread int64, locked bool) {
+	a.moderateThread(thread, "lock", map[string]interface{}{"locked": locked})
+}
+
+func (a *App) moveThread(thread int64, category int64) {
+	a.moderateThread(thread, "move", map[string]interface{}{"category": category})
+}
+
+// showAdmin shows the moderation queue and, to admins, the accounts. Other
+// sessions never get to see it.
+func (a *App) showAdmin() {
+	s := a.session.State()
+	if !s.IsModerator() {
+		a.router.Message("error", "You are not allowed to moderate.")
+		return
+	}
+
+	reports, err := a.api.Reports(s.Token)
+	if err != nil {
+		a.fail(err)
+		return
+	}
+
+	data := map[string]interface{}{"Reports": reports, "Admin": s.IsAdmin(), "User": s.Name}
+	if s.IsAdmin() {
+		if data["Accounts"], err = a.api.Accounts(s.Token); err != nil {
+			a.fail(err)
+			return
+		}
+	}
+	a.show("admin", data)
+}
+
+func (a *App) resolveReport(report int64, action string) {
+	if err := a.api.ResolveReport(a.session.State().Token, report, action); err != nil {
+		a.fail(err)
+		return
+	}
+	a.showAdmin()
+}
+
+func (a *App) ban(name string, banned bool) {
+	if err := a.api.Ban(a.session.State().Token, name, banned); err != nil {
+		a.fail(err)
+		return
+	}
+	a.showAdmin()
+
+	if banned {
+		a.router.Message("success", name+" is banned.")
+	} else {
+		a.router.Message("success", name+" is no longer banned.")
+	}
+}
+
+func (a *App) setRole(name, role string) {
+	if err := a.api.SetRole(a.session.State().Token, name, role); err != nil {
+		a.fail(err)
+		return
+	}
+	a.showAdmin()
+}
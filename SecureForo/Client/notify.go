@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Reconnection delays for the event stream.
+const (
+	minReconnectDelay = time.Second
+	maxReconnectDelay = time.Minute
+)
+
+// Notifications holds the unread notifications of the session and stops the
+// event stream feeding them.
+type Notifications struct {
+	mu     sync.Mutex
+	unread []Notification
+	cancel context.CancelFunc
+}
+
+// Add records n and returns the number of unread notifications.
+func (ns *Notifications) Add(n Notification) int {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	ns.unread = append(ns.unread, n)
+	return len(ns.unread)
+}
+
+func (ns *Notifications) Unread() []Notification {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	return append([]Notification(nil), ns.unread...)
+}
+
+func (ns *Notifications) Count() int {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	return len(ns.unread)
+}
+
+// MarkRead drops the unread notifications for which read returns true.
+func (ns *Notifications) MarkRead(read func(Notification) bool) {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	unread := ns.unread[:0]
+	for _, n := range ns.unread {
+		if !read(n) {
+			unread = append(unread, n)
+		}
+	}
+	ns.unread = unread
+}
+
+// Start runs listen until Stop is called, ending any previous listener.
+func (ns *Notifications) Start(listen func(ctx context.Context)) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	ns.mu.Lock()
+	if ns.cancel != nil {
+		ns.cancel()
+	}
+	ns.cancel = cancel
+	ns.mu.Unlock()
+
+	go listen(ctx)
+}
+
+// Stop ends the listener and forgets the unread notifications.
+func (ns *Notifications) Stop() {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	if ns.cancel != nil {
+		ns.cancel()
+		ns.cancel = nil
+	}
+	ns.unread = nil
+}
+
+// notificationText describes n for toasts and the notifications view.
+func notificationText(n Notification) string {
+	switch n.Type {
+	case "reply":
+		return n.From + " replied in “" + n.Title + "”"
+	case "mention":
+		return n.From + " mentioned you in “" + n.Title + "”"
+	default:
+		return "New message from " + n.From
+	}
+}
+
+// listen keeps the event stream of the session open, reconnecting with a
+// growing delay when it breaks, until ctx is done or the session is rejected.
+func (a *App) listen(ctx context.Context, token string) {
+	delay := minReconnectDelay
+	for {
+		started := time.Now()
+		err := a.api.Events(ctx, token, a.notify)
+		if ctx.Err() != nil {
+			return
+		}
+
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.Status == http.StatusUnauthorized {
+			return
+		}
+
+		if time.Since(started) > maxReconnectDelay {
+			delay = minReconnectDelay
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
+
+// notify shows a pushed notification as a toast and counts it as unread.
+func (a *App) notify(n Notification) {
+	count := a.notes.Add(n)
+	a.router.Toast(notificationText(n), n.Thread, n.From)
+	a.router.Unread(count)
+}
+
+// notificationItem is a Notification ready to render.
+type notificationItem struct {
+	Notification
+	Text string
+}
+
+func (a *App) showNotifications() {
+	unread := a.notes.Unread()
+
+	items := make([]notificationItem, len(unread))
+	for i, n := range unread {
+		// Newest first.
+		items[len(unread)-1-i] = notificationItem{Notification: n, Text: notificationText(n)}
+	}
+	a.show("notifications", items)
+}
+
+// openNotification opens the end of the thread or conversation a
+// notification is about.
+func (a *App) openNotification(thread int64, from string) {
+	if thread != 0 {
+		a.showThread(thread, lastPage)
+		return
+	}
+	a.showConversation(from, lastPage)
+}
+
+func (a *App) clearNotifications() {
+	a.notes.MarkRead(func(Notification) bool { return true })
+	a.showNotifications()
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//go:embed www
+var assets embed.FS
+
+// readyTimeout bounds how long Navigate waits for a view to load.
+const readyTimeout = 5 * time.Second
+
+// Router shows the client's views. The HTML, CSS and JS are embedded in the
+// binary and served by an HTTP server bound to localhost, under a random path
+// prefix so other local processes cannot read the pages. A view is an HTML
+// template from www/ rendered with the data given to Navigate.
+type Router struct {
+	ui        UI
+	templates *template.Template
+	server    *http.Server
+	base      string
+
+	mu   sync.Mutex
+	view string
+	data interface{}
+}
+
+func NewRouter(ui UI) (*Router, error) {
+	templates, err := template.ParseFS(assets, "www/*.html")
+	if err != nil {
+		return nil, err
+	}
+
+	static, err := fs.Sub(assets, "www/static")
+	if err != nil {
+		return nil, err
+	}
+
+	secret := make([]byte, 16)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	prefix := "/" + hex.EncodeToString(secret) + "/"
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Router{
+		ui:        ui,
+		templates: templates,
+		base:      "http://" + ln.Addr().String() + prefix,
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(prefix+"static/", http.StripPrefix(prefix+"static/", http.FileServer(http.FS(static))))
+	mux.HandleFunc(prefix+"view/{name}", r.serveView)
+	r.server = &http.Server{Handler: mux}
+
+	go func() {
+		if err := r.server.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+			log.Print(err)
+		}
+	}()
+
+	return r, nil
+}
+
+// Navigate renders view with data and shows it in the window.
+func (r *Router) Navigate(view string, data interface{}) error {
+	if r.templates.Lookup(view+".html") == nil {
+		return fmt.Errorf("no such view %q", view)
+	}
+
+	r.mu.Lock()
+	r.view, r.data = view, data
+	r.mu.Unlock()
+
+	if err := r.ui.Load(r.base + "view/" + view); err != nil {
+		return err
+	}
+	return r.waitReady()
+}
+
+// waitReady waits until the loaded view has run app.js, so that Message and
+// the other helpers can be used right after Navigate returns.
+func (r *Router) waitReady() error {
+	deadline := time.Now().Add(readyTimeout)
+	for time.Now().Before(deadline) {
+		if r.ui.Eval(`document.readyState === 'complete' && typeof showMessage === 'function'`).Bool() {
+			return nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return errors.New("timed out loading the view")
+}
+
+// View returns the name of the view currently shown.
+func (r *Router) View() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.view
+}
+
+func (r *Router) serveView(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	view, data := r.view, r.data
+	r.mu.Unlock()
+
+	if req.PathValue("name") != view {
+		http.NotFound(w, req)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := r.templates.ExecuteTemplate(&buf, view+".html", data); err != nil {
+		log.Printf("rendering %s: %v", view, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	buf.WriteTo(w)
+}
+
+// Message shows text in the current view's message paragraph. kind is
+// "error" or "success".
+func (r *Router) Message(kind, text string) {
+	r.ui.Eval("showMessage(" + jsString(kind) + ", " + jsString(text) + ")")
+}
+
+// RetryCountdown tells the user to wait before trying again, counting down
+// and keeping the view's submit buttons disabled until then.
+func (r *Router) RetryCountdown(wait time.Duration) {
+	r.ui.Eval("showRetryCountdown(" + strconv.Itoa(int(wait.Seconds())) + ")")
+}
+
+// FieldErrors shows inline errors next to the current view's form fields.
+func (r *Router) FieldErrors(errs map[string]string) {
+	if errs == nil {
+		errs = map[string]string{}
+	}
+	buf, _ := json.Marshal(errs)
+	r.ui.Eval("showFieldErrors(" + string(buf) + ")")
+}
+
+// Toast pops up a notification in the corner of the current view. Clicking it
+// opens the thread, or the conversation with from when thread is 0.
+func (r *Router) Toast(text string, thread int64, from string) {
+	buf, _ := json.Marshal(map[string]interface{}{"text": text, "thread": thread, "from": from})
+	r.ui.Eval("showToast(" + string(buf) + ")")
+}
+
+// Unread shows the number of unread notifications, or nothing when it is 0.
+func (r *Router) Unread(count int) {
+	r.ui.Eval("setUnread(" + strconv.Itoa(count) + ")")
+}
+
+func (r *Router) Close() error {
+	return r.server.Close()
+}
+
+// jsString quotes s as a JavaScript string literal.
+func jsString(s string) string {
+	buf, _ := json.Marshal(s)
+	return string(buf)
+}
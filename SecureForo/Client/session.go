@@ -0,0 +1,80 @@
+package main
+
+import "sync"
+
+// Session is the Go-side state shared by every view: who is logged in, with
+// which role, token and message keys. Bindings run on their own goroutines,
+// so all access goes through the mutex.
+type Session struct {
+	mu    sync.Mutex
+	name  string
+	role  string
+	token string
+	keys  *KeyPair
+
+	// challenge is set between a correct password and the TOTP code, together
+	// with the passphrase to remember the session with, if any.
+	challenge  string
+	passphrase string
+}
+
+// SessionState is a snapshot of a Session.
+type SessionState struct {
+	Name  string
+	Role  string
+	Token string
+	Keys  *KeyPair
+}
+
+func (s SessionState) IsModerator() bool {
+	return s.Role == "moderator" || s.Role == "admin"
+}
+
+func (s SessionState) IsAdmin() bool {
+	return s.Role == "admin"
+}
+
+func (s *Session) State() SessionState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return SessionState{Name: s.name, Role: s.role, Token: s.token, Keys: s.keys}
+}
+
+func (s *Session) LoggedIn() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.token != ""
+}
+
+func (s *Session) SetChallenge(name, challenge, passphrase string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.name, s.challenge, s.passphrase = name, challenge, passphrase
+}
+
+// Challenge returns the pending two-factor login, if any.
+func (s *Session) Challenge() (name, challenge, passphrase string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.name, s.challenge, s.passphrase
+}
+
+func (s *Session) LogIn(name, role, token string, keys *KeyPair) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.name, s.role, s.token, s.keys = name, role, token, keys
+	s.challenge, s.passphrase = "", ""
+}
+
+func (s *Session) Clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.name, s.role, s.token, s.keys = "", "", "", nil
+	s.challenge, s.passphrase = "", ""
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/base64"
+	"html/template"
+
+	"github.com/skip2/go-qrcode"
+)
+
+// showSecurity shows whether two-factor authentication is enabled and lets
+// the user turn it on or off.
+func (a *App) showSecurity() {
+	enabled, err := a.api.TOTPEnabled(a.session.State().Token)
+	if err != nil {
+		a.fail(err)
+		return
+	}
+	a.show("security", map[string]interface{}{"Enabled": enabled})
+}
+
+// setupTOTP starts enrolment and shows the secret as a QR code.
+func (a *App) setupTOTP() {
+	secret, uri, err := a.api.TOTPSetup(a.session.State().Token)
+	if err != nil {
+		a.fail(err)
+		return
+	}
+
+	png, err := qrcode.Encode(uri, qrcode.Medium, 256)
+	if err != nil {
+		a.fail(err)
+		return
+	}
+
+	a.show("security", map[string]interface{}{
+		"Secret": secret,
+		"QRCode": template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(png)),
+	})
+}
+
+func (a *App) enableTOTP(code string) {
+	codes, err := a.api.TOTPEnable(a.session.State().Token, code)
+	if err != nil {
+		a.fail(err)
+		return
+	}
+	a.show("security", map[string]interface{}{"RecoveryCodes": codes})
+}
+
+func (a *App) disableTOTP(code string) {
+	if err := a.api.TOTPDisable(a.session.State().Token, code); err != nil {
+		a.fail(err)
+		return
+	}
+	a.show("security", map[string]interface{}{"Enabled": false})
+}
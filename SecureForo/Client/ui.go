@@ -0,0 +1,13 @@
+package main
+
+import "github.com/zserge/lorca"
+
+// UI is the part of the browser window the client needs. A lorca.UI is one;
+// the tests use a fake so the views can be driven without Chrome.
+type UI interface {
+	Load(url string) error
+	Bind(name string, f interface{}) error
+	Eval(js string) lorca.Value
+	Done() <-chan struct{}
+	Close() error
+}
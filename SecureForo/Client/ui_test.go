@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/zserge/lorca"
+)
+
+// fakeUI stands in for the Chrome window. Load fetches the page like a
+// browser would, Eval records the scripts the client runs, and call invokes
+// a binding the way a page would.
+type fakeUI struct {
+	mu       sync.Mutex
+	bindings map[string]interface{}
+	url      string
+	page     string
+	evals    []string
+	done     chan struct{}
+}
+
+func newFakeUI() *fakeUI {
+	return &fakeUI{bindings: map[string]interface{}{}, done: make(chan struct{})}
+}
+
+func (u *fakeUI) Load(url string) error {
+	resp, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("loading " + url + ": " + resp.Status)
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.url, u.page = url, string(body)
+	return nil
+}
+
+func (u *fakeUI) Bind(name string, f interface{}) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.bindings[name] = f
+	return nil
+}
+
+// Eval records js. Every script evaluates to true, which is what the
+// router's readiness check waits for.
+func (u *fakeUI) Eval(js string) lorca.Value {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.evals = append(u.evals, js)
+	return fakeValue(true)
+}
+
+func (u *fakeUI) Done() <-chan struct{} {
+	return u.done
+}
+
+func (u *fakeUI) Close() error {
+	close(u.done)
+	return nil
+}
+
+// call invokes the named binding with args, converting them to the
+// parameter types like lorca does for values coming from JavaScript.
+func (u *fakeUI) call(t *testing.T, name string, args ...interface{}) {
+	t.Helper()
+
+	u.mu.Lock()
+	f, ok := u.bindings[name]
+	u.mu.Unlock()
+	if !ok {
+		t.Fatalf("%s is not bound", name)
+	}
+
+	fn := reflect.ValueOf(f)
+	if fn.Type().NumIn() != len(args) {
+		t.Fatalf("%s takes %d arguments, got %d", name, fn.Type().NumIn(), len(args))
+	}
+	in := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		in[i] = reflect.ValueOf(arg).Convert(fn.Type().In(i))
+	}
+	fn.Call(in)
+}
+
+// Page returns the HTML of the view last loaded.
+func (u *fakeUI) Page() string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return u.page
+}
+
+// Message returns the kind and text of the last message shown, or empty
+// strings.
+func (u *fakeUI) Message() (kind, text string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	for i := len(u.evals) - 1; i >= 0; i-- {
+		js := u.evals[i]
+		if !strings.HasPrefix(js, "showMessage(") {
+			continue
+		}
+
+		var args []string
+		if err := json.Unmarshal([]byte("["+strings.TrimSuffix(strings.TrimPrefix(js, "showMessage("), ")")+"]"), &args); err != nil || len(args) != 2 {
+			return "", ""
+		}
+		return args[0], args[1]
+	}
+	return "", ""
+}
+
+// Evaluated reports whether a script equal to js has been run.
+func (u *fakeUI) Evaluated(js string) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	for _, e := range u.evals {
+		if e == js {
+			return true
+		}
+	}
+	return false
+}
+
+// FieldErrors returns the inline errors last shown.
+func (u *fakeUI) FieldErrors() map[string]string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	errs := map[string]string{}
+	for i := len(u.evals) - 1; i >= 0; i-- {
+		js := u.evals[i]
+		if strings.HasPrefix(js, "showFieldErrors(") {
+			json.Unmarshal([]byte(strings.TrimSuffix(strings.TrimPrefix(js, "showFieldErrors("), ")")), &errs)
+			break
+		}
+	}
+	return errs
+}
+
+type fakeValue bool
+
+func (v fakeValue) Err() error                     { return nil }
+func (v fakeValue) To(x interface{}) error         { return json.Unmarshal([]byte(v.String()), x) }
+func (v fakeValue) Float() float32                 { return 0 }
+func (v fakeValue) Int() int                       { return 0 }
+func (v fakeValue) String() string                 { return strconv.FormatBool(bool(v)) }
+func (v fakeValue) Bool() bool                     { return bool(v) }
+func (v fakeValue) Object() map[string]lorca.Value { return nil }
+func (v fakeValue) Array() []lorca.Value           { return nil }
+func (v fakeValue) Bytes() []byte                  { return []byte(v.String()) }
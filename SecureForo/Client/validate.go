@@ -0,0 +1,31 @@
+package main
+
+// The rules are the server's (see rules.go), so mistakes are reported before
+// anything is sent; the server still checks everything, and its field errors
+// are shown the same way.
+
+//go:generate cp ../Server/rules.go rules.go
+
+func validateRegistration(name, password, confirm, email string) fieldErrors {
+	errs := validateNewPassword(name, password, confirm)
+	if msg := validateName(name); msg != "" {
+		errs["name"] = msg
+	}
+	if msg := validateEmail(email); msg != "" {
+		errs["email"] = msg
+	}
+	return errs
+}
+
+// validateNewPassword checks a password being chosen, at registration or
+// when resetting it. name may be empty if it is not known.
+func validateNewPassword(name, password, confirm string) fieldErrors {
+	errs := fieldErrors{}
+	if msg := validatePassword(name, password); msg != "" {
+		errs["password"] = msg
+	}
+	if password != confirm {
+		errs["confirm"] = "Passwords do not match."
+	}
+	return errs
+}
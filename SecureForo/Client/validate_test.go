@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestRulesShared checks that rules.go is still the copy of the server's.
+func TestRulesShared(t *testing.T) {
+	server, err := os.ReadFile(filepath.Join("..", "Server", "rules.go"))
+	if os.IsNotExist(err) {
+		t.Skip("the server's sources are not next to the client's")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := os.ReadFile("rules.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(client, server) {
+		t.Error("rules.go differs from ../Server/rules.go; run go generate")
+	}
+}
+
+func TestValidateRegistration(t *testing.T) {
+	tests := []struct {
+		name, password, confirm, email string
+		fields                         []string
+	}{
+		{"alice", testPassword, testPassword, "alice@example.org", nil},
+		{"alice", testPassword, testPassword, "", nil},
+		{"bo", testPassword, testPassword, "", []string{"name"}},
+		{"-alice", testPassword, testPassword, "", []string{"name"}},
+		{"alice", "short", "short", "", []string{"password"}},
+		{"alice", "password123", "password123", "", []string{"password"}},
+		{"alice", "alice-likes-tea", "alice-likes-tea", "", []string{"password"}},
+		{"alice", "aaaaaaaaaaaaaaaa", "aaaaaaaaaaaaaaaa", "", []string{"password"}},
+		{"alice", testPassword, "other", "", []string{"confirm"}},
+		{"alice", testPassword, testPassword, "Alice <alice@example.org>", []string{"email"}},
+	}
+	for _, tt := range tests {
+		errs := validateRegistration(tt.name, tt.password, tt.confirm, tt.email)
+		if len(errs) != len(tt.fields) {
+			t.Errorf("%q, %q, %q, %q: errors %v, want for %v", tt.name, tt.password, tt.confirm, tt.email, errs, tt.fields)
+			continue
+		}
+		for _, f := range tt.fields {
+			if errs[f] == "" {
+				t.Errorf("%q, %q, %q, %q: no error for %s", tt.name, tt.password, tt.confirm, tt.email, f)
+			}
+		}
+	}
+}
+
+func TestPasswordStrength(t *testing.T) {
+	tests := []struct {
+		password, want string
+	}{
+		{"", ""},
+		{"abcdefghij", "weak"},
+		{"tiger galaxy", "fair"},
+		{testPassword, "strong"},
+	}
+	for _, tt := range tests {
+		if got := passwordStrength(tt.password); got != tt.want {
+			t.Errorf("passwordStrength(%q) = %q (%.0f bits), want %q", tt.password, got, passwordBits(tt.password), tt.want)
+		}
+	}
+}
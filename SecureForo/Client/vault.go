@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+)
+
+// scrypt parameters for the vault key, as recommended for interactive logins.
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+
+	minPassphraseLen = 8
+
+	// The bounds of the cost a tampered vault file can ask for: scrypt needs
+	// 128*N*r bytes of memory and runs p times.
+	maxScryptN   = 1 << 20
+	maxScryptR   = 32
+	maxScryptP   = 16
+	maxScryptMem = 256 << 20
+)
+
+var errVaultLocked = errors.New("wrong passphrase or damaged vault")
+
+// Remembered is what the vault keeps to resume a session: the server it
+// belongs to, the user and the session token. The password is never stored.
+type Remembered struct {
+	Server string `json:"server"`
+	Name   string `json:"name"`
+	Token  string `json:"token"`
+}
+
+// vaultFile is the sealed Remembered session. The key is derived from a
+// passphrase with scrypt, so the file is useless to whoever copies it without
+// knowing the passphrase.
+type vaultFile struct {
+	N     int    `json:"n"`
+	R     int    `json:"r"`
+	P     int    `json:"p"`
+	Salt  []byte `json:"salt"`
+	Nonce []byte `json:"nonce"`
+	Box   []byte `json:"box"`
+}
+
+func vaultPath() (string, error) {
+	dir, err := dataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "vault.json"), nil
+}
+
+// HasVault reports whether a session is remembered on this machine.
+func HasVault() bool {
+	path, err := vaultPath()
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(path)
+	return err == nil
+}
+
+// SaveVault seals rem with passphrase, replacing any remembered session.
+func SaveVault(rem Remembered, passphrase string) error {
+	path, err := vaultPath()
+	if err != nil {
+		return err
+	}
+
+	v := vaultFile{N: scryptN, R: scryptR, P: scryptP, Salt: make([]byte, 16)}
+	if _, err := rand.Read(v.Salt); err != nil {
+		return err
+	}
+	key, err := v.key(passphrase)
+	if err != nil {
+		return err
+	}
+
+	var nonce [24]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return err
+	}
+	plain, err := json.Marshal(rem)
+	if err != nil {
+		return err
+	}
+	v.Nonce, v.Box = nonce[:], secretbox.Seal(nil, plain, &nonce, key)
+
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf, 0600)
+}
+
+// OpenVault returns the remembered session, or errVaultLocked if passphrase
+// is wrong.
+func OpenVault(passphrase string) (Remembered, error) {
+	var rem Remembered
+
+	path, err := vaultPath()
+	if err != nil {
+		return rem, err
+	}
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return rem, err
+	}
+
+	var v vaultFile
+	if err := json.Unmarshal(buf, &v); err != nil || len(v.Nonce) != 24 || !v.bounded() {
+		return rem, errVaultLocked
+	}
+	key, err := v.key(passphrase)
+	if err != nil {
+		return rem, errVaultLocked
+	}
+
+	var nonce [24]byte
+	copy(nonce[:], v.Nonce)
+	plain, ok := secretbox.Open(nil, v.Box, &nonce, key)
+	if !ok {
+		return rem, errVaultLocked
+	}
+	return rem, json.Unmarshal(plain, &rem)
+}
+
+// ForgetVault deletes the remembered session, if any.
+func ForgetVault() error {
+	path, err := vaultPath()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// bounded reports whether the scrypt parameters of v are within the limits.
+func (v vaultFile) bounded() bool {
+	return v.N > 1 && v.N <= maxScryptN &&
+		v.R > 0 && v.R <= maxScryptR &&
+		v.P > 0 && v.P <= maxScryptP &&
+		128*v.N*v.R <= maxScryptMem
+}
+
+func (v vaultFile) key(passphrase string) (*[32]byte, error) {
+	buf, err := scrypt.Key([]byte(passphrase), v.Salt, v.N, v.R, v.P, 32)
+	if err != nil {
+		return nil, err
+	}
+	var key [32]byte
+	copy(key[:], buf)
+	return &key, nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestVault(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv("AppData", dir)
+
+	if HasVault() {
+		t.Fatal("HasVault is true before saving")
+	}
+
+	rem := Remembered{Server: "http://localhost:8080", Name: "alice", Token: "0123456789abcdef"}
+	if err := SaveVault(rem, "open sesame"); err != nil {
+		t.Fatal(err)
+	}
+
+	path, _ := vaultPath()
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(buf), rem.Token) || strings.Contains(string(buf), rem.Name) {
+		t.Error("vault file holds the session in the clear")
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm()&0077 != 0 {
+		t.Errorf("vault file mode = %v, want it private", info.Mode().Perm())
+	}
+
+	if _, err := OpenVault("open says me"); err != errVaultLocked {
+		t.Errorf("wrong passphrase: err = %v, want errVaultLocked", err)
+	}
+
+	got, err := OpenVault("open sesame")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != rem {
+		t.Errorf("OpenVault = %+v, want %+v", got, rem)
+	}
+
+	if err := ForgetVault(); err != nil {
+		t.Fatal(err)
+	}
+	if HasVault() {
+		t.Error("HasVault is true after ForgetVault")
+	}
+	if err := ForgetVault(); err != nil {
+		t.Errorf("forgetting twice: %v", err)
+	}
+}
+
+// TestVaultTampered checks that a vault file asking for an unbounded scrypt
+// cost is refused before deriving the key.
+func TestVaultTampered(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv("AppData", dir)
+
+	if err := SaveVault(Remembered{Name: "alice"}, "open sesame"); err != nil {
+		t.Fatal(err)
+	}
+	path, _ := vaultPath()
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, params := range []string{
+		`"n":1073741824`,
+		`"r":1048576`,
+		`"p":1048576`,
+		`"r":0`,
+		`"p":-1`,
+		`"n":1048576,"r":32`,
+	} {
+		var v map[string]interface{}
+		json.Unmarshal(buf, &v)
+		json.Unmarshal([]byte("{"+params+"}"), &v)
+		tampered, _ := json.Marshal(v)
+		if err := os.WriteFile(path, tampered, 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := OpenVault("open sesame"); err != errVaultLocked {
+			t.Errorf("%s: err = %v, want errVaultLocked", params, err)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+// Helpers shared by every SecureForo view. The *Func functions called from
+// the pages are bound from Go.
+
+function val(id) {
+	return document.getElementById(id).value;
+}
+
+function checked(id) {
+	return document.getElementById(id).checked;
+}
+
+function showMessage(kind, text) {
+	var m = document.getElementById('message');
+	m.className = kind;
+	m.textContent = text;
+}
+
+// showFieldErrors writes errs[field] next to every element marked with
+// data-error-for="field", and clears the others.
+function showFieldErrors(errs) {
+	document.querySelectorAll('[data-error-for]').forEach(function (el) {
+		el.textContent = errs[el.dataset.errorFor] || '';
+	});
+}
+
+// showRetryCountdown tells the user to wait seconds before trying again and
+// disables the submit buttons until then.
+function showRetryCountdown(seconds) {
+	var buttons = document.querySelectorAll('input[type=submit]');
+	buttons.forEach(function (b) { b.disabled = true; });
+
+	function tick() {
+		if (seconds <= 0) {
+			buttons.forEach(function (b) { b.disabled = false; });
+			showMessage('', '');
+			return;
+		}
+		var m = Math.floor(seconds / 60), s = seconds % 60;
+		showMessage('error', 'Too many attempts, retry in ' + (m > 0 ? m + 'm ' : '') + s + 's.');
+		seconds--;
+		setTimeout(tick, 1000);
+	}
+	tick();
+}
+
+// setUnread shows the number of unread notifications in a badge opening the
+// notifications view, hidden when there are none.
+function setUnread(count) {
+	var badge = document.getElementById('unread');
+	if (!badge) {
+		if (!count) {
+			return;
+		}
+		badge = document.createElement('button');
+		badge.id = 'unread';
+		badge.onclick = function () { notificationsFunc(); };
+		document.body.appendChild(badge);
+	}
+	badge.textContent = count + ' unread';
+	badge.hidden = !count;
+}
+
+// showToast pops up n.text for a few seconds. Clicking it opens the thread or
+// conversation the notification is about.
+function showToast(n) {
+	var toasts = document.getElementById('toasts');
+	if (!toasts) {
+		toasts = document.createElement('div');
+		toasts.id = 'toasts';
+		document.body.appendChild(toasts);
+	}
+
+	var toast = document.createElement('div');
+	toast.className = 'toast';
+	toast.textContent = n.text;
+	toast.onclick = function () {
+		toast.remove();
+		openNotificationFunc(n.thread, n.from);
+	};
+	toasts.appendChild(toast);
+	setTimeout(function () { toast.remove(); }, 8000);
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxBodySize bounds every JSON request body the API accepts.
+const maxBodySize = 1 << 20
+
+// Server holds the dependencies shared by every API handler.
+type Server struct {
+	store      *Store
+	sessionTTL time.Duration
+
+	limiter *Limiter
+	audit   *AuditLog
+	mailer  Mailer
+	hub     *Hub
+
+	// trustProxy makes clientIP use the address the reverse proxy appended
+	// to X-Forwarded-For, for servers running behind one.
+	trustProxy bool
+
+	// challenges holds the logins waiting for a TOTP code, keyed by the hash
+	// of the challenge token. They are short-lived and not persisted.
+	challengesMu sync.Mutex
+	challenges   map[string]*loginChallenge
+}
+
+func NewServer(store *Store, audit *AuditLog, mailer Mailer) *Server {
+	return &Server{
+		store:      store,
+		sessionTTL: 24 * time.Hour,
+		limiter:    NewLimiter(),
+		audit:      audit,
+		mailer:     mailer,
+		hub:        NewHub(),
+		challenges: map[string]*loginChallenge{},
+	}
+}
+
+func (s *Server) Routes() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("POST /register", s.handleRegister)
+	mux.HandleFunc("POST /login", s.handleLogin)
+	mux.HandleFunc("POST /login/totp", s.handleLoginTOTP)
+	mux.HandleFunc("POST /logout", s.requireSession(s.handleLogout))
+	mux.HandleFunc("GET /me", s.requireSession(s.handleMe))
+	mux.HandleFunc("GET /events", s.requireSession(s.handleEvents))
+	mux.HandleFunc("POST /password/reset-request", s.handleResetRequest)
+	mux.HandleFunc("POST /password/reset", s.handleReset)
+
+	mux.HandleFunc("GET /totp", s.requireSession(s.handleTOTPStatus))
+	mux.HandleFunc("POST /totp/setup", s.requireSession(s.handleTOTPSetup))
+	mux.HandleFunc("POST /totp/enable", s.requireSession(s.handleTOTPEnable))
+	mux.HandleFunc("POST /totp/disable", s.requireSession(s.handleTOTPDisable))
+
+	mux.HandleFunc("GET /categories", s.requireSession(s.handleCategories))
+	mux.HandleFunc("GET /categories/{id}/threads", s.requireSession(s.handleListThreads))
+	mux.HandleFunc("POST /threads", s.requireSession(s.handleCreateThread))
+	mux.HandleFunc("GET /threads/{id}", s.requireSession(s.handleThread))
+	mux.HandleFunc("POST /threads/{id}/posts", s.requireSession(s.handleCreatePost))
+
+	mux.HandleFunc("PUT /keys", s.requireSession(s.handleSetKey))
+	mux.HandleFunc("GET /users/{name}/key", s.requireSession(s.handleUserKey))
+	mux.HandleFunc("POST /messages", s.requireSession(s.handleSendMessage))
+	mux.HandleFunc("GET /messages", s.requireSession(s.handleConversations))
+	mux.HandleFunc("GET /messages/{peer}", s.requireSession(s.handleConversation))
+
+	mux.HandleFunc("POST /posts/{id}/report", s.requireSession(s.handleReportPost))
+	mux.HandleFunc("POST /mod/threads/{id}/{action}", s.requireRole(RoleModerator, s.handleModerateThread))
+	mux.HandleFunc("POST /mod/users/{name}/ban", s.requireRole(RoleModerator, s.handleBan))
+	mux.HandleFunc("GET /mod/reports", s.requireRole(RoleModerator, s.handleReports))
+	mux.HandleFunc("POST /mod/reports/{id}/resolve", s.requireRole(RoleModerator, s.handleResolveReport))
+	mux.HandleFunc("GET /admin/users", s.requireRole(RoleAdmin, s.handleUsers))
+	mux.HandleFunc("POST /admin/users/{name}/role", s.requireRole(RoleAdmin, s.handleSetRole))
+
+	return mux
+}
+
+type ctxKey int
+
+const userKey ctxKey = iota
+
+// requireSession rejects requests without a valid "Authorization: Bearer"
+// token and makes the session's user available through currentUser.
+func (s *Server) requireSession(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := bearerToken(r)
+		if token == "" {
+			writeError(w, http.StatusUnauthorized, "missing session token")
+			return
+		}
+
+		user, err := s.store.SessionUser(token)
+		if err != nil {
+			writeError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+
+		next(w, r.WithContext(context.WithValue(r.Context(), userKey, user)))
+	}
+}
+
+func currentUser(r *http.Request) User {
+	u, _ := r.Context().Value(userKey).(User)
+	return u
+}
+
+func bearerToken(r *http.Request) string {
+	h := r.Header.Get("Authorization")
+	if !strings.HasPrefix(h, "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(h, "Bearer "))
+}
+
+// clientIP returns the address a request comes from. Behind a proxy that is
+// the rightmost X-Forwarded-For entry, the one the proxy appended; those
+// before it come from the client and may be forged.
+func (s *Server) clientIP(r *http.Request) string {
+	if s.trustProxy {
+		if fwd := r.Header.Values("X-Forwarded-For"); len(fwd) > 0 {
+			entries := strings.Split(fwd[len(fwd)-1], ",")
+			if ip := strings.TrimSpace(entries[len(entries)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("writing response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+// internalError logs err and answers with a generic 500 so no internals leak
+// to the client.
+func internalError(w http.ResponseWriter, err error) {
+	log.Print(err)
+	writeError(w, http.StatusInternalServerError, "internal server error")
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestServer returns a server keeping its data in a temporary directory.
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+
+	store, err := OpenStore(filepath.Join(t.TempDir(), "secureforo.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewServer(store, NewAuditLog(io.Discard), LogMailer{})
+}
+
+// login creates the user name and returns the token of a session of theirs.
+func login(t *testing.T, s *Server, name string) string {
+	t.Helper()
+
+	if err := s.store.CreateUser(User{Name: name}); err != nil {
+		t.Fatal(err)
+	}
+	token, err := s.store.CreateSession(name, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// do sends a request to the server's routes and decodes the JSON answer into
+// v, if not nil.
+func do(t *testing.T, s *Server, method, target, token, body string, v interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.RemoteAddr = "192.0.2.1:1234"
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	s.Routes().ServeHTTP(w, r)
+
+	if v != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+			t.Fatalf("%s %s: %v in %q", method, target, err, w.Body)
+		}
+	}
+	return w
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		trustProxy bool
+		forwarded  []string
+		want       string
+	}{
+		{false, nil, "192.0.2.1"},
+		{false, []string{"203.0.113.9"}, "192.0.2.1"},
+		{true, nil, "192.0.2.1"},
+		{true, []string{"203.0.113.9"}, "203.0.113.9"},
+		{true, []string{"10.6.6.6, 203.0.113.9"}, "203.0.113.9"},
+		{true, []string{"10.6.6.6", "10.7.7.7, 203.0.113.9"}, "203.0.113.9"},
+		{true, []string{""}, "192.0.2.1"},
+	}
+	for _, tt := range tests {
+		s := &Server{trustProxy: tt.trustProxy}
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "192.0.2.1:1234"
+		for _, v := range tt.forwarded {
+			r.Header.Add("X-Forwarded-For", v)
+		}
+		if got := s.clientIP(r); got != tt.want {
+			t.Errorf("trustProxy %v, X-Forwarded-For %q: clientIP = %s, want %s", tt.trustProxy, tt.forwarded, got, tt.want)
+		}
+	}
+}
+
+func TestPageBounds(t *testing.T) {
+	tests := []struct {
+		page       Page
+		start, end int
+	}{
+		{Page{Page: 1, PerPage: 20, Total: 0}, 0, 0},
+		{Page{Page: 1, PerPage: 20, Total: 45}, 0, 20},
+		{Page{Page: 3, PerPage: 20, Total: 45}, 40, 45},
+		{Page{Page: 4, PerPage: 20, Total: 45}, 45, 45},
+		{Page{Page: 3, PerPage: 20, Total: 40}, 40, 40},
+		{Page{Page: 1<<63 - 1, PerPage: 20, Total: 45}, 45, 45},
+		{Page{Page: 1<<62 + 1, PerPage: 100, Total: 45}, 45, 45},
+	}
+	for _, tt := range tests {
+		start, end := tt.page.bounds()
+		if start != tt.start || end != tt.end {
+			t.Errorf("%+v.bounds() = %d, %d, want %d, %d", tt.page, start, end, tt.start, tt.end)
+		}
+	}
+}
+
+func TestHugePage(t *testing.T) {
+	s := newTestServer(t)
+	token := login(t, s, "alice")
+	login(t, s, "bob")
+
+	thread, err := s.store.CreateThread(1, "alice", "Hello", "First post")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.store.SendMessage(Message{From: "alice", To: "bob"}); err != nil {
+		t.Fatal(err)
+	}
+
+	const huge = "?page=9223372036854775807"
+	for _, target := range []string{
+		"/categories/1/threads" + huge,
+		"/threads/" + strconv.FormatInt(thread.ID, 10) + huge,
+		"/messages/bob" + huge,
+		"/messages/bob" + huge + "&per_page=100",
+	} {
+		w := do(t, s, "GET", target, token, "", nil)
+		if w.Code != http.StatusOK {
+			t.Errorf("GET %s: status %d, want 200: %s", target, w.Code, w.Body)
+		}
+	}
+}
+
+// TestPromoteAdmins checks that only accounts that exist when the server
+// starts are made admins, and that registering an admin's name does not.
+func TestPromoteAdmins(t *testing.T) {
+	s := newTestServer(t)
+	login(t, s, "alice")
+
+	if err := promoteAdmins(s.store, "alice, root"); err != nil {
+		t.Fatal(err)
+	}
+	if u, _ := s.store.User("alice"); u.Role != RoleAdmin {
+		t.Errorf("alice's role = %q, want admin", u.Role)
+	}
+
+	w := do(t, s, "POST", "/register", "", `{"name": "root", "password": "purple tiger galaxy 9z"}`, nil)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("register: status %d: %s", w.Code, w.Body)
+	}
+	if u, _ := s.store.User("root"); u.Role != RoleUser {
+		t.Errorf("registered root's role = %q, want user", u.Role)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+// Outcomes recorded in the audit log.
+const (
+	auditSuccess     = "success"
+	auditBadPassword = "bad_password"
+	auditUnknownUser = "unknown_user"
+	auditThrottled   = "throttled"
+	auditLocked      = "locked"
+	auditBanned      = "banned"
+	auditTOTPPending = "totp_required"
+	auditTOTPFailed  = "totp_failed"
+)
+
+// AuditEntry is one line of the audit log.
+type AuditEntry struct {
+	Time    time.Time `json:"time"`
+	Event   string    `json:"event"`
+	Name    string    `json:"name"`
+	IP      string    `json:"ip"`
+	Outcome string    `json:"outcome"`
+}
+
+// AuditLog appends one JSON object per line to w.
+type AuditLog struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewAuditLog(w io.Writer) *AuditLog {
+	return &AuditLog{w: w}
+}
+
+func (a *AuditLog) Record(event, name, ip, outcome string) {
+	buf, err := json.Marshal(AuditEntry{Time: time.Now().UTC(), Event: event, Name: name, IP: ip, Outcome: outcome})
+	if err != nil {
+		log.Printf("audit: %v", err)
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, err := a.w.Write(append(buf, '\n')); err != nil {
+		log.Printf("audit: %v", err)
+	}
+}
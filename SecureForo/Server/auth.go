@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+type credentials struct {
+	Name      string `json:"name"`
+	Password  string `json:"password"`
+	Email     string `json:"email,omitempty"`
+	PublicKey []byte `json:"public_key,omitempty"`
+}
+
+// dummyHash is compared against when a login names an unknown user, so the
+// response time does not reveal which accounts exist.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("secureforo-dummy-password"), bcrypt.DefaultCost)
+
+func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
+	var c credentials
+	if !readJSON(w, r, &c) {
+		return
+	}
+
+	if errs := validateRegistration(c.Name, c.Password, c.Email); len(errs) > 0 {
+		writeFieldErrors(w, errs)
+		return
+	}
+
+	if c.PublicKey != nil && len(c.PublicKey) != keySize {
+		writeError(w, http.StatusBadRequest, "public key must be 32 bytes")
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(c.Password), bcrypt.DefaultCost)
+	if errors.Is(err, bcrypt.ErrPasswordTooLong) {
+		writeError(w, http.StatusBadRequest, "password is too long")
+		return
+	}
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+
+	err = s.store.CreateUser(User{Name: c.Name, PasswordHash: hash, Email: c.Email, PublicKey: c.PublicKey, Role: RoleUser})
+	if errors.Is(err, ErrUserExists) {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, map[string]string{"name": c.Name})
+}
+
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	var c credentials
+	if !readJSON(w, r, &c) {
+		return
+	}
+
+	ip := s.clientIP(r)
+	if !s.allowLogin(w, c.Name, ip) {
+		return
+	}
+
+	user, err := s.store.User(c.Name)
+	if err != nil {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(c.Password))
+		s.loginFailed(w, c.Name, ip, auditUnknownUser, "invalid name or password")
+		return
+	}
+
+	if bcrypt.CompareHashAndPassword(user.PasswordHash, []byte(c.Password)) != nil {
+		s.loginFailed(w, c.Name, ip, auditBadPassword, "invalid name or password")
+		return
+	}
+
+	if user.Banned {
+		s.audit.Record("login", user.Name, ip, auditBanned)
+		writeError(w, http.StatusForbidden, ErrBanned.Error())
+		return
+	}
+
+	if user.TOTP.Enabled {
+		challenge, err := s.newChallenge(user.Name)
+		if err != nil {
+			internalError(w, err)
+			return
+		}
+		s.audit.Record("login", user.Name, ip, auditTOTPPending)
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"name":          user.Name,
+			"totp_required": true,
+			"challenge":     challenge,
+		})
+		return
+	}
+
+	s.loginSucceeded(w, user, ip)
+}
+
+// allowLogin answers 429 with a Retry-After header when the account or the
+// address has failed too often recently. Otherwise the attempt counts as a
+// failure until loginSucceeded; an attempt that stops at the TOTP challenge
+// stays counted until the code is accepted.
+func (s *Server) allowLogin(w http.ResponseWriter, name, ip string) bool {
+	now := time.Now()
+
+	wait, ok := s.limiter.Reserve(name, ip, now)
+	if ok {
+		return true
+	}
+
+	outcome := auditThrottled
+	if s.limiter.Locked(name, now) {
+		outcome = auditLocked
+	}
+	s.audit.Record("login", name, ip, outcome)
+	writeThrottled(w, wait)
+	return false
+}
+
+// writeThrottled answers 429, telling the client to wait before retrying.
+func writeThrottled(w http.ResponseWriter, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	writeJSON(w, http.StatusTooManyRequests, map[string]interface{}{
+		"error":       fmt.Sprintf("Too many attempts, retry in %s.", time.Duration(seconds)*time.Second),
+		"retry_after": seconds,
+	})
+}
+
+// loginFailed answers a failed attempt, which allowLogin already counted.
+func (s *Server) loginFailed(w http.ResponseWriter, name, ip, outcome, msg string) {
+	s.audit.Record("login", name, ip, outcome)
+	writeError(w, http.StatusUnauthorized, msg)
+}
+
+func (s *Server) loginSucceeded(w http.ResponseWriter, user User, ip string) {
+	s.limiter.Success(user.Name, ip)
+	s.audit.Record("login", user.Name, ip, auditSuccess)
+	s.startSession(w, user)
+}
+
+// startSession answers a successful login with a new session token.
+func (s *Server) startSession(w http.ResponseWriter, user User) {
+	token, err := s.store.CreateSession(user.Name, s.sessionTTL)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"name": user.Name, "role": userRole(user), "token": token})
+}
+
+// handleMe describes the session's user.
+func (s *Server) handleMe(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	writeJSON(w, http.StatusOK, map[string]string{"name": user.Name, "role": userRole(user)})
+}
+
+// userRole returns the role of u, defaulting accounts created before roles
+// existed to RoleUser.
+func userRole(u User) string {
+	if u.Role == "" {
+		return RoleUser
+	}
+	return u.Role
+}
+
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if err := s.store.DeleteSession(bearerToken(r)); err != nil {
+		internalError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
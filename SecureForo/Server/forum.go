@@ -0,0 +1,342 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxTitleLen = 120
+	maxPostLen  = 10000
+
+	defaultPerPage = 20
+	maxPerPage     = 100
+)
+
+type Category struct {
+	ID          int64  `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// defaultCategories are created the first time the server starts.
+var defaultCategories = []Category{
+	{Name: "General", Description: "Anything about SecureForo"},
+	{Name: "Security", Description: "Cryptography, privacy and keeping safe online"},
+	{Name: "Off-topic", Description: "Everything else"},
+}
+
+// Thread is a topic in a category. Hidden threads are only visible to
+// moderators, and locked threads only accept replies from moderators.
+type Thread struct {
+	ID       int64     `json:"id"`
+	Category int64     `json:"category"`
+	Title    string    `json:"title"`
+	Author   string    `json:"author"`
+	Created  time.Time `json:"created"`
+	Updated  time.Time `json:"updated"`
+	Replies  int       `json:"replies"`
+	Hidden   bool      `json:"hidden,omitempty"`
+	Locked   bool      `json:"locked,omitempty"`
+}
+
+// Post is a message in a thread. The body of a hidden post is only shown to
+// moderators.
+type Post struct {
+	ID      int64     `json:"id"`
+	Thread  int64     `json:"thread"`
+	Author  string    `json:"author"`
+	Body    string    `json:"body"`
+	Created time.Time `json:"created"`
+	Hidden  bool      `json:"hidden,omitempty"`
+}
+
+var ErrLocked = errors.New("thread is locked")
+
+// Page describes which slice of a list a response holds.
+type Page struct {
+	Page    int `json:"page"`
+	PerPage int `json:"per_page"`
+	Total   int `json:"total"`
+}
+
+// bounds returns the slice indexes of the page in a list of p.Total items.
+// Pages past the end are empty; the check on p.Page comes before the
+// multiplication so that a huge page number cannot overflow it.
+func (p Page) bounds() (int, int) {
+	start := p.Total
+	if p.Page-1 <= p.Total/p.PerPage {
+		start = min((p.Page-1)*p.PerPage, p.Total)
+	}
+	end := start + min(p.PerPage, p.Total-start)
+	return start, end
+}
+
+func (s *Store) ListCategories() []Category {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cats := make([]Category, len(s.Categories))
+	for i, c := range s.Categories {
+		cats[i] = *c
+	}
+	return cats
+}
+
+// hasCategory reports whether id is a known category. The caller must hold
+// s.mu.
+func (s *Store) hasCategory(id int64) bool {
+	for _, c := range s.Categories {
+		if c.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// ListThreads returns a page of the category's threads, most recently active
+// first. Hidden threads are left out unless moderator is set.
+func (s *Store) ListThreads(category int64, page Page, moderator bool) ([]Thread, Page, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.hasCategory(category) {
+		return nil, page, ErrNotFound
+	}
+
+	var threads []Thread
+	for _, t := range s.Threads {
+		if t.Category == category && (moderator || !t.Hidden) {
+			threads = append(threads, *t)
+		}
+	}
+	sort.Slice(threads, func(i, j int) bool {
+		if threads[i].Updated.Equal(threads[j].Updated) {
+			return threads[i].ID > threads[j].ID
+		}
+		return threads[i].Updated.After(threads[j].Updated)
+	})
+
+	page.Total = len(threads)
+	start, end := page.bounds()
+	return threads[start:end], page, nil
+}
+
+// CreateThread opens a thread in category whose first post is body.
+func (s *Store) CreateThread(category int64, author, title, body string) (Thread, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.hasCategory(category) {
+		return Thread{}, ErrNotFound
+	}
+
+	now := time.Now().UTC()
+	t := &Thread{
+		ID:       s.nextID(),
+		Category: category,
+		Title:    title,
+		Author:   author,
+		Created:  now,
+		Updated:  now,
+	}
+	s.Threads[t.ID] = t
+	s.Posts[t.ID] = []*Post{{ID: s.nextID(), Thread: t.ID, Author: author, Body: body, Created: now}}
+
+	return *t, s.save()
+}
+
+// Thread returns a thread and a page of its posts, oldest first. Unless
+// moderator is set, hidden threads are not found and hidden posts have no
+// body.
+func (s *Store) Thread(id int64, page Page, moderator bool) (Thread, []Post, Page, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.Threads[id]
+	if !ok || (t.Hidden && !moderator) {
+		return Thread{}, nil, page, ErrNotFound
+	}
+
+	all := s.Posts[id]
+	page.Total = len(all)
+	start, end := page.bounds()
+
+	posts := make([]Post, 0, end-start)
+	for _, p := range all[start:end] {
+		post := *p
+		if post.Hidden && !moderator {
+			post.Body = ""
+		}
+		posts = append(posts, post)
+	}
+	return *t, posts, page, nil
+}
+
+// CreatePost adds a reply to thread. Only moderators can reply to hidden or
+// locked threads.
+func (s *Store) CreatePost(thread int64, author, body string, moderator bool) (Post, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.Threads[thread]
+	if !ok || (t.Hidden && !moderator) {
+		return Post{}, ErrNotFound
+	}
+	if t.Locked && !moderator {
+		return Post{}, ErrLocked
+	}
+
+	p := &Post{ID: s.nextID(), Thread: thread, Author: author, Body: body, Created: time.Now().UTC()}
+	s.Posts[thread] = append(s.Posts[thread], p)
+	t.Updated = p.Created
+	t.Replies++
+
+	return *p, s.save()
+}
+
+func (s *Server) handleCategories(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"categories": s.store.ListCategories()})
+}
+
+func (s *Server) handleListThreads(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	threads, page, err := s.store.ListThreads(id, pageParams(r), currentUser(r).IsModerator())
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, "category not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"threads": nonNil(threads), "page": page})
+}
+
+func (s *Server) handleCreateThread(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Category int64  `json:"category"`
+		Title    string `json:"title"`
+		Body     string `json:"body"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	req.Title = strings.TrimSpace(req.Title)
+	if req.Title == "" || len(req.Title) > maxTitleLen {
+		writeError(w, http.StatusBadRequest, "title must be between 1 and "+strconv.Itoa(maxTitleLen)+" characters")
+		return
+	}
+	if !validBody(w, req.Body) {
+		return
+	}
+
+	t, err := s.store.CreateThread(req.Category, currentUser(r).Name, req.Title, req.Body)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, "category not found")
+		return
+	}
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+
+	s.notifyPost(Post{Thread: t.ID, Author: t.Author, Body: req.Body, Created: t.Created})
+	writeJSON(w, http.StatusCreated, t)
+}
+
+func (s *Server) handleThread(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	t, posts, page, err := s.store.Thread(id, pageParams(r), currentUser(r).IsModerator())
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, "thread not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"thread": t, "posts": posts, "page": page})
+}
+
+func (s *Server) handleCreatePost(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Body string `json:"body"`
+	}
+	if !readJSON(w, r, &req) || !validBody(w, req.Body) {
+		return
+	}
+
+	user := currentUser(r)
+	p, err := s.store.CreatePost(id, user.Name, req.Body, user.IsModerator())
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, "thread not found")
+		return
+	}
+	if errors.Is(err, ErrLocked) {
+		writeError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+
+	s.notifyPost(p)
+	writeJSON(w, http.StatusCreated, p)
+}
+
+func validBody(w http.ResponseWriter, body string) bool {
+	if strings.TrimSpace(body) == "" || len(body) > maxPostLen {
+		writeError(w, http.StatusBadRequest, "post must be between 1 and "+strconv.Itoa(maxPostLen)+" characters")
+		return false
+	}
+	return true
+}
+
+// pathID parses the {id} path segment.
+func pathID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid id")
+		return 0, false
+	}
+	return id, true
+}
+
+// pageParams reads the "page" and "per_page" query parameters, falling back to
+// the first page of defaultPerPage items.
+func pageParams(r *http.Request) Page {
+	p := Page{Page: 1, PerPage: defaultPerPage}
+
+	if n, err := strconv.Atoi(r.URL.Query().Get("page")); err == nil && n > 0 {
+		p.Page = n
+	}
+	if n, err := strconv.Atoi(r.URL.Query().Get("per_page")); err == nil && n > 0 {
+		p.PerPage = n
+	}
+	if p.PerPage > maxPerPage {
+		p.PerPage = maxPerPage
+	}
+	return p
+}
+
+// nonNil makes empty lists encode as [] rather than null.
+func nonNil(threads []Thread) []Thread {
+	if threads == nil {
+		return []Thread{}
+	}
+	return threads
+}
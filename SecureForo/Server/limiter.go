@@ -0,0 +1,219 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// LimitPolicy describes how failed logins, or password reset requests, for
+// one key (an account or an IP address) are throttled. The first Free
+// failures cost nothing; after that every failure doubles the wait before the
+// next attempt, starting at BaseDelay and capped at MaxDelay. From
+// LockoutAfter failures on, the key is locked for LockoutFor. Failures are
+// forgotten after Forget without any.
+type LimitPolicy struct {
+	Free         int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	LockoutAfter int
+	LockoutFor   time.Duration
+	Forget       time.Duration
+}
+
+var (
+	// accountPolicy protects a single account from password guessing.
+	accountPolicy = LimitPolicy{
+		Free:         3,
+		BaseDelay:    time.Second,
+		MaxDelay:     5 * time.Minute,
+		LockoutAfter: 10,
+		LockoutFor:   15 * time.Minute,
+		Forget:       time.Hour,
+	}
+
+	// ipPolicy is looser, since several users can share an address, but
+	// still slows down credential stuffing across many accounts.
+	ipPolicy = LimitPolicy{
+		Free:         10,
+		BaseDelay:    time.Second,
+		MaxDelay:     5 * time.Minute,
+		LockoutAfter: 50,
+		LockoutFor:   time.Hour,
+		Forget:       time.Hour,
+	}
+
+	// resetAccountPolicy keeps an account's inbox from being flooded with
+	// reset mails. Every request counts, since none of them can fail.
+	resetAccountPolicy = LimitPolicy{
+		Free:         3,
+		BaseDelay:    time.Minute,
+		MaxDelay:     30 * time.Minute,
+		LockoutAfter: 10,
+		LockoutFor:   6 * time.Hour,
+		Forget:       6 * time.Hour,
+	}
+
+	// resetIPPolicy keeps a single address from mailing many accounts
+	// through the SMTP relay.
+	resetIPPolicy = LimitPolicy{
+		Free:         10,
+		BaseDelay:    10 * time.Second,
+		MaxDelay:     30 * time.Minute,
+		LockoutAfter: 30,
+		LockoutFor:   6 * time.Hour,
+		Forget:       6 * time.Hour,
+	}
+)
+
+type failures struct {
+	count        int
+	last         time.Time
+	blockedUntil time.Time
+}
+
+// bucket holds the failures of the keys of one kind, throttled by p.
+type bucket struct {
+	m map[string]*failures
+	p LimitPolicy
+}
+
+func newBucket(p LimitPolicy) *bucket {
+	return &bucket{m: map[string]*failures{}, p: p}
+}
+
+// Limiter tracks failed logins, and password reset requests, per account and
+// per IP address. State is kept in memory only, so a restart clears it.
+type Limiter struct {
+	mu        sync.Mutex
+	accounts  *bucket
+	ips       *bucket
+	resets    *bucket
+	resetIPs  *bucket
+	lastPrune time.Time
+}
+
+func NewLimiter() *Limiter {
+	return &Limiter{
+		accounts: newBucket(accountPolicy),
+		ips:      newBucket(ipPolicy),
+		resets:   newBucket(resetAccountPolicy),
+		resetIPs: newBucket(resetIPPolicy),
+	}
+}
+
+// Reserve reports whether a login for account from ip may be attempted now,
+// and if not, how long the client has to wait. An allowed attempt is counted
+// as a failure at once, under the same lock as the check, so that guesses
+// sent in parallel cannot all get past the check before any of them has
+// failed; Success takes it back.
+func (l *Limiter) Reserve(account, ip string, now time.Time) (time.Duration, bool) {
+	return l.reserve(l.accounts, l.ips, account, ip, now)
+}
+
+// ReserveReset is Reserve for password reset requests, which are counted
+// apart from logins and only taken back by ResetDone.
+func (l *Limiter) ReserveReset(account, ip string, now time.Time) (time.Duration, bool) {
+	return l.reserve(l.resets, l.resetIPs, account, ip, now)
+}
+
+func (l *Limiter) reserve(accounts, ips *bucket, account, ip string, now time.Time) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	wait := blockedFor(accounts.m[account], now)
+	if w := blockedFor(ips.m[ip], now); w > wait {
+		wait = w
+	}
+	if wait > 0 {
+		return wait, false
+	}
+
+	l.prune(now)
+	accounts.fail(account, now)
+	ips.fail(ip, now)
+	return 0, true
+}
+
+// Success forgets the failures of account and takes back the attempt
+// reserved for ip, if any. The IP's other failures are kept, so an attacker
+// cannot reset them by logging into an account of their own.
+func (l *Limiter) Success(account, ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.accounts.m, account)
+	if f, ok := l.ips.m[ip]; ok && f.count > 0 {
+		f.count--
+	}
+}
+
+// ResetDone forgets the failed logins of account, whose password was just
+// reset, and the reset requests made for it, and takes back the request
+// reserved for ip, the address the reset was requested from.
+func (l *Limiter) ResetDone(account, ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.accounts.m, account)
+	delete(l.resets.m, account)
+	if f, ok := l.resetIPs.m[ip]; ok && f.count > 0 {
+		f.count--
+	}
+}
+
+// Locked reports whether account is in a lockout rather than a short
+// backoff, for the audit log.
+func (l *Limiter) Locked(account string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, ok := l.accounts.m[account]
+	return ok && f.count >= accountPolicy.LockoutAfter && now.Before(f.blockedUntil)
+}
+
+func (b *bucket) fail(key string, now time.Time) {
+	p := b.p
+	f, ok := b.m[key]
+	if !ok || now.Sub(f.last) > p.Forget {
+		f = &failures{}
+		b.m[key] = f
+	}
+
+	f.count++
+	f.last = now
+
+	switch {
+	case f.count >= p.LockoutAfter:
+		f.blockedUntil = now.Add(p.LockoutFor)
+	case f.count > p.Free:
+		delay := p.BaseDelay << uint(f.count-p.Free-1)
+		if delay > p.MaxDelay || delay <= 0 {
+			delay = p.MaxDelay
+		}
+		f.blockedUntil = now.Add(delay)
+	}
+}
+
+func blockedFor(f *failures, now time.Time) time.Duration {
+	if f == nil {
+		return 0
+	}
+	return f.blockedUntil.Sub(now)
+}
+
+// prune drops keys whose failures are old enough to be forgotten. It runs at
+// most once a minute. The caller must hold l.mu.
+func (l *Limiter) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < time.Minute {
+		return
+	}
+	l.lastPrune = now
+
+	for _, b := range []*bucket{l.accounts, l.ips, l.resets, l.resetIPs} {
+		for k, f := range b.m {
+			if now.Sub(f.last) > b.p.Forget && now.After(f.blockedUntil) {
+				delete(b.m, k)
+			}
+		}
+	}
+}
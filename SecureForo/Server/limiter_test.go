@@ -0,0 +1,72 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLimiterBackoff(t *testing.T) {
+	l := NewLimiter()
+	now := time.Now()
+
+	for i := 0; i < accountPolicy.Free; i++ {
+		if _, ok := l.Reserve("alice", "192.0.2.1", now); !ok {
+			t.Fatalf("attempt %d blocked within the free ones", i+1)
+		}
+	}
+	if _, ok := l.Reserve("alice", "192.0.2.1", now); !ok {
+		t.Fatal("first attempt past the free ones blocked")
+	}
+	wait, ok := l.Reserve("alice", "192.0.2.1", now)
+	if ok || wait != accountPolicy.BaseDelay {
+		t.Errorf("Reserve = %v, %v, want %v, false", wait, ok, accountPolicy.BaseDelay)
+	}
+	if _, ok := l.Reserve("alice", "192.0.2.1", now.Add(accountPolicy.BaseDelay)); !ok {
+		t.Error("attempt blocked after the delay")
+	}
+
+	l.Success("alice", "192.0.2.1")
+	if _, ok := l.Reserve("alice", "192.0.2.1", now.Add(accountPolicy.BaseDelay)); !ok {
+		t.Error("attempt blocked after a success")
+	}
+}
+
+// TestLimiterParallel sends many guesses at once: only those the policy lets
+// through one after the other may pass.
+func TestLimiterParallel(t *testing.T) {
+	l := NewLimiter()
+	now := time.Now()
+
+	var allowed atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, ok := l.Reserve("alice", "192.0.2.1", now); ok {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got, want := int(allowed.Load()), accountPolicy.Free+1; got != want {
+		t.Errorf("%d parallel attempts allowed, want %d", got, want)
+	}
+}
+
+// TestLimiterSharedIP checks that successful logins from one address do not
+// add up to a lockout of the address.
+func TestLimiterSharedIP(t *testing.T) {
+	l := NewLimiter()
+	now := time.Now()
+
+	for i := 0; i < ipPolicy.LockoutAfter*2; i++ {
+		if _, ok := l.Reserve("user", "192.0.2.1", now); !ok {
+			t.Fatalf("login %d from a shared address blocked", i+1)
+		}
+		l.Success("user", "192.0.2.1")
+	}
+}
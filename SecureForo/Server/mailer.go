@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Mailer delivers the emails the server sends, such as password reset codes.
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// LogMailer writes emails to the server log instead of sending them. Only
+// meant for local development.
+type LogMailer struct{}
+
+func (LogMailer) Send(to, subject, body string) error {
+	log.Printf("mail to %s: %s\n%s", to, subject, body)
+	return nil
+}
+
+// FileMailer writes each email to its own file in Dir, for local development
+// and tests.
+type FileMailer struct {
+	Dir string
+}
+
+func (m FileMailer) Send(to, subject, body string) error {
+	if err := os.MkdirAll(m.Dir, 0700); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), strings.NewReplacer("@", "_at_", "/", "_").Replace(to))
+	return os.WriteFile(filepath.Join(m.Dir, name), []byte(message("", to, subject, body)), 0600)
+}
+
+// SMTPMailer sends emails through an SMTP server. Auth may be nil for servers
+// that do not need it.
+type SMTPMailer struct {
+	Addr string
+	From string
+	Auth smtp.Auth
+}
+
+func (m SMTPMailer) Send(to, subject, body string) error {
+	return smtp.SendMail(m.Addr, m.Auth, m.From, []string{to}, []byte(message(m.From, to, subject, body)))
+}
+
+// message formats a plain text email.
+func message(from, to, subject, body string) string {
+	var b strings.Builder
+	if from != "" {
+		fmt.Fprintf(&b, "From: %s\r\n", from)
+	}
+	fmt.Fprintf(&b, "To: %s\r\n", to)
+	fmt.Fprintf(&b, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return b.String()
+}
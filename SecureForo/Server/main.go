@@ -0,0 +1,162 @@
+/*
+SecureForo server.
+
+HTTP API used by the SecureForo Lorca client. Users register and log in with a
+name and password; passwords are stored as bcrypt hashes and a successful
+login returns a session token that the client sends back as
+"Authorization: Bearer <token>".
+
+	POST /register	{"name": "...", "password": "...", "email": "..."}
+	POST /login	{"name": "...", "password": "..."}	-> {"name": "...", "token": "..."}
+	POST /logout	(authenticated)
+
+Failed logins are throttled per account and per client address with an
+exponential backoff and, after repeated failures, a temporary lockout; while
+blocked, /login answers 429 with a Retry-After header. Every login attempt is
+appended to the audit log.
+
+A forgotten password is reset with a single-use code mailed to the account's
+email address, valid for 30 minutes. Resetting ends all sessions of the user.
+Requests are throttled per name and per client address, like logins.
+Mail goes through SMTP when -smtp is set; otherwise it is written to -mail-dir
+or, failing that, to the log.
+
+	POST /password/reset-request	{"name": "..."}
+	POST /password/reset		{"token": "...", "password": "..."}
+
+Users have a role: user, moderator or admin. The accounts named with -admin are
+made admins when the server starts; they must be registered first, since
+registering never makes an admin. Moderators can hide, lock and move threads, ban users and work
+through the queue of reported posts; admins also assign roles.
+
+	GET  /me				-> {"name": "...", "role": "..."}
+	POST /posts/{id}/report			{"reason": "..."}
+	POST /mod/threads/{id}/hide		{"hidden": true}
+	POST /mod/threads/{id}/lock		{"locked": true}
+	POST /mod/threads/{id}/move		{"category": 2}
+	POST /mod/users/{name}/ban		{"banned": true}
+	GET  /mod/reports
+	POST /mod/reports/{id}/resolve		{"action": "dismiss" | "hide_post"}
+	GET  /admin/users
+	POST /admin/users/{name}/role		{"role": "moderator"}
+
+Accounts can enable TOTP two-factor authentication. For those, /login answers
+{"totp_required": true, "challenge": "..."} instead of a token, and the login
+is finished by sending a 6-digit code or a recovery code:
+
+	POST /login/totp	{"challenge": "...", "code": "..."}	-> {"name": "...", "token": "..."}
+	GET  /totp		-> {"enabled": true|false}
+	POST /totp/setup	-> {"secret": "...", "uri": "otpauth://..."}
+	POST /totp/enable	{"code": "..."}	-> {"recovery_codes": [...]}
+	POST /totp/disable	{"code": "..."}
+
+The forum is split in categories holding threads of posts. Every forum route
+needs a session, and lists take "page" and "per_page" query parameters.
+
+	GET  /categories
+	GET  /categories/{id}/threads
+	POST /threads			{"category": 1, "title": "...", "body": "..."}
+	GET  /threads/{id}		-> thread and a page of its posts
+	POST /threads/{id}/posts	{"body": "..."}
+
+Private messages are end-to-end encrypted: clients publish an X25519 public key
+(at registration or with PUT /keys) and seal every message with NaCl box, so
+the server only stores ciphertext.
+
+	PUT  /keys			{"public_key": "<base64>"}
+	GET  /users/{name}/key
+	POST /messages			{"to": "...", "nonce": "<base64>", "box": "<base64>", ...}
+	GET  /messages			-> conversations of the current user
+	GET  /messages/{peer}		-> a page of the messages exchanged with peer
+
+Connected clients are told about replies in threads they posted in, mentions of
+their @name and new private messages through a server-sent events stream. Each
+event is named after its type ("reply", "mention" or "message") and carries
+the notification as JSON.
+
+	GET  /events			-> text/event-stream
+*/
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strings"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:8080", "address to listen on")
+	data := flag.String("data", "secureforo.json", "file where the forum data is stored")
+	auditPath := flag.String("audit", "secureforo-audit.log", "file where login attempts are logged")
+	trustProxy := flag.Bool("trust-proxy", false, "take client addresses from the entry the reverse proxy appends to X-Forwarded-For")
+	smtpAddr := flag.String("smtp", "", "SMTP server (host:port) used to send mail")
+	smtpFrom := flag.String("smtp-from", "secureforo@localhost", "sender address of the mails")
+	mailDir := flag.String("mail-dir", "", "write mails to this directory instead of sending them")
+	admins := flag.String("admin", "", "comma separated names of the admin accounts")
+	flag.Parse()
+
+	store, err := OpenStore(*data)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	auditFile, err := os.OpenFile(*auditPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer auditFile.Close()
+
+	if err := promoteAdmins(store, *admins); err != nil {
+		log.Fatal(err)
+	}
+
+	srv := NewServer(store, NewAuditLog(auditFile), newMailer(*smtpAddr, *smtpFrom, *mailDir))
+	srv.trustProxy = *trustProxy
+
+	log.Printf("SecureForo server listening on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, srv.Routes()))
+}
+
+// promoteAdmins gives RoleAdmin to the existing accounts of the comma
+// separated names. Names nobody registered yet are only logged: whoever
+// registered them first would get the role.
+func promoteAdmins(store *Store, names string) error {
+	for _, name := range strings.Split(names, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		err := store.SetRole(name, RoleAdmin)
+		if errors.Is(err, ErrUserNotFound) {
+			log.Printf("admin account %q does not exist; register it and restart the server", name)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newMailer picks the mailer from the flags. SMTP credentials, when needed,
+// are read from SMTP_USERNAME and SMTP_PASSWORD.
+func newMailer(smtpAddr, from, dir string) Mailer {
+	switch {
+	case smtpAddr != "":
+		m := SMTPMailer{Addr: smtpAddr, From: from}
+		if user := os.Getenv("SMTP_USERNAME"); user != "" {
+			host, _, _ := net.SplitHostPort(smtpAddr)
+			m.Auth = smtp.PlainAuth("", user, os.Getenv("SMTP_PASSWORD"), host)
+		}
+		return m
+	case dir != "":
+		return FileMailer{Dir: dir}
+	default:
+		return LogMailer{}
+	}
+}
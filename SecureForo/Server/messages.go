@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+)
+
+const (
+	// keySize is the length of an X25519 public key, nonceSize the length of
+	// a NaCl box nonce.
+	keySize   = 32
+	nonceSize = 24
+
+	maxBoxLen = maxPostLen + 16
+)
+
+// Message is a private message sealed by the sender with NaCl box. The server
+// cannot read Box; it only keeps both public keys used to seal it so either
+// party can open it later, even after rotating their own key.
+type Message struct {
+	ID           int64     `json:"id"`
+	From         string    `json:"from"`
+	To           string    `json:"to"`
+	SenderKey    []byte    `json:"sender_key"`
+	RecipientKey []byte    `json:"recipient_key"`
+	Nonce        []byte    `json:"nonce"`
+	Box          []byte    `json:"box"`
+	Sent         time.Time `json:"sent"`
+}
+
+// Conversation summarises the messages exchanged with one peer.
+type Conversation struct {
+	Peer     string    `json:"peer"`
+	Messages int       `json:"messages"`
+	Last     time.Time `json:"last"`
+}
+
+var ErrStaleKey = errors.New("message was sealed with an outdated key")
+
+// SendMessage stores m after checking that it was sealed with the current
+// keys of both users.
+func (s *Store) SendMessage(m Message) (Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	from, ok := s.Users[m.From]
+	if !ok {
+		return Message{}, ErrUserNotFound
+	}
+	to, ok := s.Users[m.To]
+	if !ok {
+		return Message{}, ErrUserNotFound
+	}
+	if !bytes.Equal(from.PublicKey, m.SenderKey) || !bytes.Equal(to.PublicKey, m.RecipientKey) {
+		return Message{}, ErrStaleKey
+	}
+
+	m.ID = s.nextID()
+	m.Sent = time.Now().UTC()
+	s.Messages = append(s.Messages, &m)
+	return m, s.save()
+}
+
+// Conversations lists the peers user has exchanged messages with, most
+// recent first.
+func (s *Store) Conversations(user string) []Conversation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	byPeer := map[string]*Conversation{}
+	for _, m := range s.Messages {
+		peer := m.To
+		if m.To == user {
+			peer = m.From
+		} else if m.From != user {
+			continue
+		}
+
+		c, ok := byPeer[peer]
+		if !ok {
+			c = &Conversation{Peer: peer}
+			byPeer[peer] = c
+		}
+		c.Messages++
+		c.Last = m.Sent
+	}
+
+	convs := make([]Conversation, 0, len(byPeer))
+	for _, c := range byPeer {
+		convs = append(convs, *c)
+	}
+	sort.Slice(convs, func(i, j int) bool { return convs[i].Last.After(convs[j].Last) })
+	return convs
+}
+
+// Conversation returns a page of the messages between user and peer, oldest
+// first.
+func (s *Store) Conversation(user, peer string, page Page) ([]Message, Page) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var all []Message
+	for _, m := range s.Messages {
+		if (m.From == user && m.To == peer) || (m.From == peer && m.To == user) {
+			all = append(all, *m)
+		}
+	}
+
+	page.Total = len(all)
+	start, end := page.bounds()
+	return append([]Message{}, all[start:end]...), page
+}
+
+func (s *Server) handleSetKey(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		PublicKey []byte `json:"public_key"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if len(req.PublicKey) != keySize {
+		writeError(w, http.StatusBadRequest, "public key must be 32 bytes")
+		return
+	}
+
+	if err := s.store.SetPublicKey(currentUser(r).Name, req.PublicKey); err != nil {
+		internalError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleUserKey(w http.ResponseWriter, r *http.Request) {
+	u, err := s.store.User(r.PathValue("name"))
+	if err != nil || u.PublicKey == nil {
+		writeError(w, http.StatusNotFound, "user has no public key")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"name": u.Name, "public_key": u.PublicKey})
+}
+
+func (s *Server) handleSendMessage(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		To           string `json:"to"`
+		SenderKey    []byte `json:"sender_key"`
+		RecipientKey []byte `json:"recipient_key"`
+		Nonce        []byte `json:"nonce"`
+		Box          []byte `json:"box"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if len(req.SenderKey) != keySize || len(req.RecipientKey) != keySize ||
+		len(req.Nonce) != nonceSize || len(req.Box) == 0 || len(req.Box) > maxBoxLen {
+		writeError(w, http.StatusBadRequest, "invalid encrypted message")
+		return
+	}
+
+	m, err := s.store.SendMessage(Message{
+		From:         currentUser(r).Name,
+		To:           req.To,
+		SenderKey:    req.SenderKey,
+		RecipientKey: req.RecipientKey,
+		Nonce:        req.Nonce,
+		Box:          req.Box,
+	})
+	switch {
+	case errors.Is(err, ErrUserNotFound):
+		writeError(w, http.StatusNotFound, "recipient not found")
+	case errors.Is(err, ErrStaleKey):
+		writeError(w, http.StatusConflict, err.Error())
+	case err != nil:
+		internalError(w, err)
+	default:
+		s.hub.Publish(m.To, Notification{Type: notifyMessage, From: m.From, Created: m.Sent})
+		writeJSON(w, http.StatusCreated, m)
+	}
+}
+
+func (s *Server) handleConversations(w http.ResponseWriter, r *http.Request) {
+	convs := s.store.Conversations(currentUser(r).Name)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"conversations": convs})
+}
+
+func (s *Server) handleConversation(w http.ResponseWriter, r *http.Request) {
+	msgs, page := s.store.Conversation(currentUser(r).Name, r.PathValue("peer"), pageParams(r))
+	writeJSON(w, http.StatusOK, map[string]interface{}{"messages": msgs, "page": page})
+}
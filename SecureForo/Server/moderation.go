@@ -0,0 +1,402 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Roles, from least to most privileged. Moderators can hide, lock and move
+// threads, hide posts, handle reports and ban users; admins can also change
+// roles.
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+var roleRank = map[string]int{RoleUser: 0, "": 0, RoleModerator: 1, RoleAdmin: 2}
+
+func validRole(role string) bool {
+	return role == RoleUser || role == RoleModerator || role == RoleAdmin
+}
+
+// HasRole reports whether u has at least the given role.
+func (u User) HasRole(role string) bool {
+	return roleRank[u.Role] >= roleRank[role]
+}
+
+func (u User) IsModerator() bool {
+	return u.HasRole(RoleModerator)
+}
+
+const maxReasonLen = 500
+
+// Report is a post flagged by a user for the moderators.
+type Report struct {
+	ID         int64     `json:"id"`
+	Post       int64     `json:"post"`
+	Thread     int64     `json:"thread"`
+	Reporter   string    `json:"reporter"`
+	Reason     string    `json:"reason"`
+	Created    time.Time `json:"created"`
+	Resolved   bool      `json:"resolved,omitempty"`
+	ResolvedBy string    `json:"resolved_by,omitempty"`
+	Action     string    `json:"action,omitempty"`
+}
+
+// ReportItem is an open report together with the reported post, as listed in
+// the moderation queue.
+type ReportItem struct {
+	Report
+	Author string `json:"author"`
+	Body   string `json:"body"`
+	Title  string `json:"title"`
+}
+
+// Report resolutions.
+const (
+	actionDismiss  = "dismiss"
+	actionHidePost = "hide_post"
+)
+
+var ErrForbidden = errors.New("not allowed")
+
+// findPost returns a post by ID. The caller must hold s.mu.
+func (s *Store) findPost(id int64) (*Post, bool) {
+	for _, posts := range s.Posts {
+		for _, p := range posts {
+			if p.ID == id {
+				return p, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// UpdateThread applies change to a thread and saves it.
+func (s *Store) UpdateThread(id int64, change func(t *Thread) error) (Thread, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.Threads[id]
+	if !ok {
+		return Thread{}, ErrNotFound
+	}
+
+	updated := *t
+	if err := change(&updated); err != nil {
+		return Thread{}, err
+	}
+	if !s.hasCategory(updated.Category) {
+		return Thread{}, ErrNotFound
+	}
+
+	*t = updated
+	return *t, s.save()
+}
+
+// SetBanned bans or unbans the named user on behalf of by. Moderators can
+// only ban plain users; admins anyone but themselves. Banning ends every
+// session of the user.
+func (s *Store) SetBanned(by User, name string, banned bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.Users[name]
+	if !ok {
+		return ErrUserNotFound
+	}
+	if u.Name == by.Name || (u.IsModerator() && !by.HasRole(RoleAdmin)) {
+		return ErrForbidden
+	}
+
+	u.Banned = banned
+	if banned {
+		s.deleteUserSessions(name)
+	}
+	return s.save()
+}
+
+func (s *Store) SetRole(name, role string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.Users[name]
+	if !ok {
+		return ErrUserNotFound
+	}
+	u.Role = role
+	return s.save()
+}
+
+// Account is the part of a user shown to admins.
+type Account struct {
+	Name    string    `json:"name"`
+	Role    string    `json:"role"`
+	Banned  bool      `json:"banned"`
+	Created time.Time `json:"created"`
+}
+
+// ListUsers returns every account, sorted by name, without secrets.
+func (s *Store) ListUsers() []Account {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	users := make([]Account, 0, len(s.Users))
+	for _, u := range s.Users {
+		users = append(users, Account{Name: u.Name, Role: userRole(*u), Banned: u.Banned, Created: u.Created})
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Name < users[j].Name })
+	return users
+}
+
+func (s *Store) CreateReport(post int64, reporter, reason string) (Report, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.findPost(post)
+	if !ok {
+		return Report{}, ErrNotFound
+	}
+
+	r := &Report{
+		ID:       s.nextID(),
+		Post:     p.ID,
+		Thread:   p.Thread,
+		Reporter: reporter,
+		Reason:   reason,
+		Created:  time.Now().UTC(),
+	}
+	s.Reports = append(s.Reports, r)
+	return *r, s.save()
+}
+
+// OpenReports returns the moderation queue, oldest report first.
+func (s *Store) OpenReports() []ReportItem {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	items := []ReportItem{}
+	for _, r := range s.Reports {
+		if r.Resolved {
+			continue
+		}
+
+		item := ReportItem{Report: *r}
+		if p, ok := s.findPost(r.Post); ok {
+			item.Author, item.Body = p.Author, p.Body
+		}
+		if t, ok := s.Threads[r.Thread]; ok {
+			item.Title = t.Title
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// ResolveReport closes a report. With actionHidePost the reported post is
+// hidden too.
+func (s *Store) ResolveReport(id int64, by, action string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, r := range s.Reports {
+		if r.ID != id {
+			continue
+		}
+
+		if action == actionHidePost {
+			if p, ok := s.findPost(r.Post); ok {
+				p.Hidden = true
+			}
+		}
+		r.Resolved, r.ResolvedBy, r.Action = true, by, action
+		return s.save()
+	}
+	return ErrNotFound
+}
+
+// requireRole is requireSession for routes restricted to role and above.
+func (s *Server) requireRole(role string, next http.HandlerFunc) http.HandlerFunc {
+	return s.requireSession(func(w http.ResponseWriter, r *http.Request) {
+		if !currentUser(r).HasRole(role) {
+			writeError(w, http.StatusForbidden, "you are not allowed to do this")
+			return
+		}
+		next(w, r)
+	})
+}
+
+func (s *Server) handleReportPost(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" || len(req.Reason) > maxReasonLen {
+		writeError(w, http.StatusBadRequest, "reason must be between 1 and 500 characters")
+		return
+	}
+
+	report, err := s.store.CreateReport(id, currentUser(r).Name, req.Reason)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, "post not found")
+		return
+	}
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, report)
+}
+
+// handleModerateThread serves the hide, lock and move actions.
+func (s *Server) handleModerateThread(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Hidden   *bool  `json:"hidden"`
+		Locked   *bool  `json:"locked"`
+		Category *int64 `json:"category"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	t, err := s.store.UpdateThread(id, func(t *Thread) error {
+		switch r.PathValue("action") {
+		case "hide":
+			if req.Hidden == nil {
+				return errBadModeration
+			}
+			t.Hidden = *req.Hidden
+		case "lock":
+			if req.Locked == nil {
+				return errBadModeration
+			}
+			t.Locked = *req.Locked
+		case "move":
+			if req.Category == nil {
+				return errBadModeration
+			}
+			t.Category = *req.Category
+		default:
+			return errBadModeration
+		}
+		return nil
+	})
+	switch {
+	case errors.Is(err, ErrNotFound):
+		writeError(w, http.StatusNotFound, "thread or category not found")
+	case errors.Is(err, errBadModeration):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case err != nil:
+		internalError(w, err)
+	default:
+		writeJSON(w, http.StatusOK, t)
+	}
+}
+
+var errBadModeration = errors.New("unknown moderation action or missing value")
+
+func (s *Server) handleBan(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Banned bool `json:"banned"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	err := s.store.SetBanned(currentUser(r), r.PathValue("name"), req.Banned)
+	switch {
+	case errors.Is(err, ErrUserNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, ErrForbidden):
+		writeError(w, http.StatusForbidden, "you cannot ban this user")
+	case err != nil:
+		internalError(w, err)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *Server) handleReports(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"reports": s.store.OpenReports()})
+}
+
+func (s *Server) handleResolveReport(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Action string `json:"action"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if req.Action != actionDismiss && req.Action != actionHidePost {
+		writeError(w, http.StatusBadRequest, "action must be dismiss or hide_post")
+		return
+	}
+
+	err := s.store.ResolveReport(id, currentUser(r).Name, req.Action)
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, "report not found")
+		return
+	}
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleUsers(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"users": s.store.ListUsers()})
+}
+
+func (s *Server) handleSetRole(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Role string `json:"role"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if !validRole(req.Role) {
+		writeError(w, http.StatusBadRequest, "role must be user, moderator or admin")
+		return
+	}
+
+	name := r.PathValue("name")
+	if name == currentUser(r).Name {
+		writeError(w, http.StatusForbidden, "you cannot change your own role")
+		return
+	}
+
+	err := s.store.SetRole(name, req.Role)
+	if errors.Is(err, ErrUserNotFound) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// heartbeatInterval is how often an idle event stream gets a comment, which
+// keeps proxies from closing it and notices ended sessions.
+const heartbeatInterval = 25 * time.Second
+
+// Notification types.
+const (
+	notifyReply   = "reply"
+	notifyMention = "mention"
+	notifyMessage = "message"
+)
+
+// Notification tells a user about a reply in a thread they posted in, a
+// mention of their @name, or a new private message. Message contents are
+// encrypted for the recipient, so only the sender is given.
+type Notification struct {
+	Type    string    `json:"type"`
+	From    string    `json:"from"`
+	Thread  int64     `json:"thread,omitempty"`
+	Post    int64     `json:"post,omitempty"`
+	Title   string    `json:"title,omitempty"`
+	Created time.Time `json:"created"`
+}
+
+// Hub hands notifications to the event streams of the connected users.
+// Nothing is kept for users who are offline.
+type Hub struct {
+	mu   sync.Mutex
+	subs map[string]map[chan Notification]bool
+}
+
+func NewHub() *Hub {
+	return &Hub{subs: map[string]map[chan Notification]bool{}}
+}
+
+// Subscribe returns a channel receiving the user's notifications, and the
+// function to call once done with it.
+func (h *Hub) Subscribe(user string) (<-chan Notification, func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ch := make(chan Notification, 16)
+	if h.subs[user] == nil {
+		h.subs[user] = map[chan Notification]bool{}
+	}
+	h.subs[user][ch] = true
+
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		delete(h.subs[user], ch)
+		if len(h.subs[user]) == 0 {
+			delete(h.subs, user)
+		}
+	}
+}
+
+// Publish sends n to every stream of user. A stream too slow to keep up
+// misses it rather than holding up the sender.
+func (h *Hub) Publish(user string, n Notification) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.subs[user] {
+		select {
+		case ch <- n:
+		default:
+		}
+	}
+}
+
+var mentionPattern = regexp.MustCompile(`@([A-Za-z0-9][A-Za-z0-9_.-]*)`)
+
+// mentions returns the existing users mentioned in body, leaving out author.
+func (s *Server) mentions(body, author string) map[string]bool {
+	names := map[string]bool{}
+	for _, m := range mentionPattern.FindAllStringSubmatch(body, -1) {
+		name := m[1]
+		if _, err := s.store.User(name); err != nil {
+			// "@bob." at the end of a sentence.
+			name = strings.TrimRight(name, "._-")
+			if _, err := s.store.User(name); err != nil {
+				continue
+			}
+		}
+		if name != author {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+// notifyPost tells the users mentioned in p, and everyone else who posted in
+// its thread, about it. Hidden threads stay quiet.
+func (s *Server) notifyPost(p Post) {
+	t, participants, err := s.store.Participants(p.Thread)
+	if err != nil || t.Hidden {
+		return
+	}
+
+	n := Notification{From: p.Author, Thread: t.ID, Post: p.ID, Title: t.Title, Created: p.Created}
+
+	mentioned := s.mentions(p.Body, p.Author)
+	for name := range mentioned {
+		n.Type = notifyMention
+		s.hub.Publish(name, n)
+	}
+
+	n.Type = notifyReply
+	for _, name := range participants {
+		if name != p.Author && !mentioned[name] {
+			s.hub.Publish(name, n)
+		}
+	}
+}
+
+// Participants returns a thread and the authors of its posts.
+func (s *Store) Participants(thread int64) (Thread, []string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.Threads[thread]
+	if !ok {
+		return Thread{}, nil, ErrNotFound
+	}
+
+	seen := map[string]bool{}
+	var names []string
+	for _, p := range s.Posts[thread] {
+		if !seen[p.Author] {
+			seen[p.Author] = true
+			names = append(names, p.Author)
+		}
+	}
+	return *t, names, nil
+}
+
+// handleEvents streams the user's notifications as server-sent events, one
+// event per notification named after its type. The stream ends when the
+// session does.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	user := currentUser(r)
+	token := bearerToken(r)
+
+	events, done := s.hub.Subscribe(user.Name)
+	defer done()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := s.store.SessionUser(token); err != nil {
+				return
+			}
+			fmt.Fprint(w, ": ping\n\n")
+		case n := <-events:
+			buf, err := json.Marshal(n)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", n.Type, buf)
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
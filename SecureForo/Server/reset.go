@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const resetTTL = 30 * time.Minute
+
+var ErrInvalidReset = errors.New("reset code is invalid or has expired")
+
+// PasswordReset is a pending password reset. Its token is single use. IP is
+// the address the reset was requested from.
+type PasswordReset struct {
+	User    string    `json:"user"`
+	IP      string    `json:"ip,omitempty"`
+	Expires time.Time `json:"expires"`
+}
+
+// CreateReset issues a reset token for the named user, requested from ip,
+// replacing any earlier one. It returns the user's email address to send it
+// to.
+func (s *Store) CreateReset(name, ip string) (token, email string, err error) {
+	raw, err := randomBytes(16)
+	if err != nil {
+		return "", "", err
+	}
+	token = hex.EncodeToString(raw)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.Users[name]
+	if !ok {
+		return "", "", ErrUserNotFound
+	}
+
+	now := time.Now()
+	for k, r := range s.Resets {
+		if r.User == name || now.After(r.Expires) {
+			delete(s.Resets, k)
+		}
+	}
+	s.Resets[hashToken(token)] = &PasswordReset{User: name, IP: ip, Expires: now.Add(resetTTL).UTC()}
+	return token, u.Email, s.save()
+}
+
+// ResetUser returns the user a reset token belongs to, without using it.
+func (s *Store) ResetUser(token string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.Resets[hashToken(token)]
+	if !ok || time.Now().After(r.Expires) {
+		return "", ErrInvalidReset
+	}
+	return r.User, nil
+}
+
+// ResetPassword uses token to set a new password hash, and ends every
+// session of the user. It returns the reset used.
+func (s *Store) ResetPassword(token string, passwordHash []byte) (PasswordReset, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := hashToken(token)
+	r, ok := s.Resets[key]
+	if !ok || time.Now().After(r.Expires) {
+		return PasswordReset{}, ErrInvalidReset
+	}
+	delete(s.Resets, key)
+
+	u, ok := s.Users[r.User]
+	if !ok {
+		return PasswordReset{}, ErrInvalidReset
+	}
+	u.PasswordHash = passwordHash
+	s.deleteUserSessions(u.Name)
+
+	return *r, s.save()
+}
+
+// handleResetRequest answers 202 right away and mails the code in the
+// background, so neither the answer nor its timing tells which accounts exist
+// or have an email address. Requests are throttled per name and per address,
+// whether the account exists or not, so that nobody can flood an inbox or the
+// mail relay.
+func (s *Server) handleResetRequest(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	ip := s.clientIP(r)
+	if wait, ok := s.limiter.ReserveReset(req.Name, ip, time.Now()); !ok {
+		s.audit.Record("reset_request", req.Name, ip, auditThrottled)
+		writeThrottled(w, wait)
+		return
+	}
+
+	go s.sendReset(req.Name, ip)
+
+	writeJSON(w, http.StatusAccepted, map[string]string{
+		"status": "If the account has an email address, a reset code was sent to it.",
+	})
+}
+
+func (s *Server) sendReset(name, ip string) {
+	user, err := s.store.User(name)
+	if err != nil || user.Email == "" {
+		s.audit.Record("reset_request", name, ip, auditUnknownUser)
+		return
+	}
+
+	token, email, err := s.store.CreateReset(user.Name, ip)
+	if err != nil {
+		log.Printf("creating reset for %s: %v", user.Name, err)
+		return
+	}
+
+	body := fmt.Sprintf("Someone asked to reset the password of your SecureForo account %q.\n\n"+
+		"If it was you, enter this code in the SecureForo client within %d minutes:\n\n\t%s\n\n"+
+		"Otherwise you can ignore this email.\n", user.Name, int(resetTTL.Minutes()), token)
+
+	if err := s.mailer.Send(email, "SecureForo password reset", body); err != nil {
+		log.Printf("sending reset for %s: %v", user.Name, err)
+		return
+	}
+	s.audit.Record("reset_request", user.Name, ip, auditSuccess)
+}
+
+func (s *Server) handleReset(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	name, err := s.store.ResetUser(req.Token)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if msg := validatePassword(name, req.Password); msg != "" {
+		writeFieldErrors(w, fieldErrors{"password": msg})
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+
+	reset, err := s.store.ResetPassword(req.Token, hash)
+	if errors.Is(err, ErrInvalidReset) {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+
+	s.limiter.ResetDone(reset.User, reset.IP)
+	s.audit.Record("reset", reset.User, s.clientIP(r), auditSuccess)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// chanMailer hands the mails sent to a channel.
+type chanMailer chan string
+
+func (m chanMailer) Send(to, subject, body string) error {
+	m <- to
+	return nil
+}
+
+func TestResetRequestThrottled(t *testing.T) {
+	s := newTestServer(t)
+	mails := make(chanMailer, 100)
+	s.mailer = mails
+	if err := s.store.CreateUser(User{Name: "alice", Email: "alice@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	sent := 0
+	for i := 0; i < resetAccountPolicy.Free+3; i++ {
+		w := do(t, s, "POST", "/password/reset-request", "", `{"name": "alice"}`, nil)
+		switch {
+		case i <= resetAccountPolicy.Free && w.Code == http.StatusAccepted:
+			sent++
+		case i > resetAccountPolicy.Free && w.Code == http.StatusTooManyRequests:
+			if w.Header().Get("Retry-After") == "" {
+				t.Error("429 without Retry-After")
+			}
+		default:
+			t.Fatalf("request %d: status %d: %s", i+1, w.Code, w.Body)
+		}
+	}
+
+	for i := 0; i < sent; i++ {
+		select {
+		case <-mails:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%d mails sent, want %d", i, sent)
+		}
+	}
+	select {
+	case <-mails:
+		t.Error("a throttled request sent a mail")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// TestResetRequestThrottledPerIP checks that one address cannot mail many
+// accounts, existing or not.
+func TestResetRequestThrottledPerIP(t *testing.T) {
+	s := newTestServer(t)
+
+	for i := 0; i <= resetIPPolicy.Free; i++ {
+		body := `{"name": "user` + string(rune('a'+i)) + `"}`
+		if w := do(t, s, "POST", "/password/reset-request", "", body, nil); w.Code != http.StatusAccepted {
+			t.Fatalf("request %d: status %d, want 202", i+1, w.Code)
+		}
+	}
+	if w := do(t, s, "POST", "/password/reset-request", "", `{"name": "another"}`, nil); w.Code != http.StatusTooManyRequests {
+		t.Errorf("status %d, want 429", w.Code)
+	}
+}
+
+// bodyMailer hands the bodies of the mails sent to a channel.
+type bodyMailer chan string
+
+func (m bodyMailer) Send(to, subject, body string) error {
+	m <- body
+	return nil
+}
+
+// TestResetReleasesRequest checks that a completed reset takes back the
+// request of the address it was asked from.
+func TestResetReleasesRequest(t *testing.T) {
+	s := newTestServer(t)
+	mails := make(bodyMailer, 1)
+	s.mailer = mails
+	if err := s.store.CreateUser(User{Name: "alice", Email: "alice@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if w := do(t, s, "POST", "/password/reset-request", "", `{"name": "alice"}`, nil); w.Code != http.StatusAccepted {
+		t.Fatalf("status %d, want 202", w.Code)
+	}
+	var body string
+	select {
+	case body = <-mails:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reset mail sent")
+	}
+	_, token, _ := strings.Cut(body, "\n\n\t")
+	token, _, _ = strings.Cut(token, "\n")
+
+	// Bring the address up to its free requests.
+	for i := 1; i < resetIPPolicy.Free; i++ {
+		body := `{"name": "user` + string(rune('a'+i)) + `"}`
+		if w := do(t, s, "POST", "/password/reset-request", "", body, nil); w.Code != http.StatusAccepted {
+			t.Fatalf("request %d: status %d, want 202", i+1, w.Code)
+		}
+	}
+
+	w := do(t, s, "POST", "/password/reset", "", `{"token": "`+token+`", "password": "purple tiger galaxy 9z"}`, nil)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("reset: status %d: %s", w.Code, w.Body)
+	}
+
+	// One request more than without the reset is let through.
+	for i := 0; i < 2; i++ {
+		if w := do(t, s, "POST", "/password/reset-request", "", `{"name": "another"}`, nil); w.Code != http.StatusAccepted {
+			t.Fatalf("request %d after the reset: status %d, want 202", i+1, w.Code)
+		}
+	}
+	if w := do(t, s, "POST", "/password/reset-request", "", `{"name": "another"}`, nil); w.Code != http.StatusTooManyRequests {
+		t.Errorf("status %d, want 429", w.Code)
+	}
+}
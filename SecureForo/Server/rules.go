@@ -0,0 +1,145 @@
+// The registration rules are shared by the server and the client, so that the
+// client can report mistakes before anything is sent and the server enforces
+// the same rules on whatever it is sent. This file is the original;
+// Client/rules.go is a copy made by go generate in Client. Edit this one.
+
+package main
+
+import (
+	"math"
+	"net/mail"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+const (
+	minNameLen = 3
+	maxNameLen = 20
+
+	minPasswordLen = 10
+	// maxPasswordLen is bcrypt's input limit, in bytes.
+	maxPasswordLen = 72
+
+	// minPasswordBits is the lowest estimated entropy accepted for a password.
+	minPasswordBits = 50
+	// strongPasswordBits is where the strength meter says "strong".
+	strongPasswordBits = 70
+)
+
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// commonPasswords are rejected whatever their estimated strength.
+var commonPasswords = map[string]bool{
+	"password": true, "password1": true, "password123": true, "passw0rd": true,
+	"123456789": true, "1234567890": true, "12345678910": true, "qwertyuiop": true,
+	"qwerty123": true, "iloveyou": true, "letmein": true, "welcome1": true,
+	"admin123": true, "sunshine": true, "football": true, "princess": true,
+	"secureforo": true, "changeme": true, "trustno1": true, "abc123456": true,
+}
+
+// fieldErrors maps form field names to what is wrong with them.
+type fieldErrors map[string]string
+
+func validateName(name string) string {
+	switch {
+	case len(name) < minNameLen || len(name) > maxNameLen:
+		return "Name must be between 3 and 20 characters."
+	case !validName.MatchString(name):
+		return "Name may only contain letters, digits, '.', '_' and '-', and must start with a letter or digit."
+	}
+	return ""
+}
+
+// validatePassword checks a password being chosen. name may be empty if it
+// is not known.
+func validatePassword(name, password string) string {
+	lower := strings.ToLower(password)
+
+	switch {
+	case len([]rune(password)) < minPasswordLen:
+		return "Password must be at least 10 characters."
+	case len(password) > maxPasswordLen:
+		return "Password must be at most 72 bytes."
+	case commonPasswords[lower]:
+		return "This password is too common."
+	case name != "" && strings.Contains(lower, strings.ToLower(name)):
+		return "Password must not contain your name."
+	case passwordBits(password) < minPasswordBits:
+		return "Password is too weak, try a longer one or mix in other kinds of characters."
+	}
+	return ""
+}
+
+// validateEmail accepts an empty address, since email is optional.
+func validateEmail(email string) string {
+	if email == "" {
+		return ""
+	}
+	if addr, err := mail.ParseAddress(email); err != nil || addr.Address != email {
+		return "Email address is not valid."
+	}
+	return ""
+}
+
+// passwordStrength describes the estimated strength of password for the
+// meter next to the password field.
+func passwordStrength(password string) string {
+	switch bits := passwordBits(password); {
+	case password == "":
+		return ""
+	case bits < minPasswordBits:
+		return "weak"
+	case bits < strongPasswordBits:
+		return "fair"
+	default:
+		return "strong"
+	}
+}
+
+// passwordBits estimates the entropy of password from the character classes
+// it uses. Characters that repeat or continue a sequence of the previous one
+// ("aaa", "abc", "321") only count for one bit.
+func passwordBits(password string) float64 {
+	var lower, upper, digit, symbol, other bool
+	for _, r := range password {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < unicode.MaxASCII && unicode.IsPrint(r):
+			symbol = true
+		default:
+			other = true
+		}
+	}
+
+	pool := 0
+	for _, c := range []struct {
+		used bool
+		size int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 100}} {
+		if c.used {
+			pool += c.size
+		}
+	}
+	if pool == 0 {
+		return 0
+	}
+
+	perChar := math.Log2(float64(pool))
+	bits := 0.0
+	prev := rune(-1)
+	for _, r := range password {
+		if d := r - prev; d >= -1 && d <= 1 {
+			bits++
+		} else {
+			bits += perChar
+		}
+		prev = r
+	}
+	return bits
+}
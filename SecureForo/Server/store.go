@@ -0,0 +1,238 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var (
+	ErrUserExists   = errors.New("user already exists")
+	ErrUserNotFound = errors.New("user not found")
+	ErrNoSession    = errors.New("invalid or expired session")
+	ErrNotFound     = errors.New("not found")
+	ErrBanned       = errors.New("account is banned")
+)
+
+// User is an account as stored by the server. The password is only kept as a
+// bcrypt hash. Email is optional and only used for password resets. Role is
+// one of RoleUser, RoleModerator or RoleAdmin; banned users cannot log in.
+// PublicKey is the user's X25519 key for private messages; the
+// matching private key never leaves the client.
+type User struct {
+	Name         string    `json:"name"`
+	PasswordHash []byte    `json:"password_hash"`
+	Email        string    `json:"email,omitempty"`
+	PublicKey    []byte    `json:"public_key,omitempty"`
+	TOTP         TOTP      `json:"totp"`
+	Role         string    `json:"role,omitempty"`
+	Banned       bool      `json:"banned,omitempty"`
+	Created      time.Time `json:"created"`
+}
+
+// Session links a login token to a user. Only the SHA-256 of the token is
+// stored, so a leaked data file does not leak usable tokens.
+type Session struct {
+	User    string    `json:"user"`
+	Expires time.Time `json:"expires"`
+}
+
+// Store keeps the server state in memory and mirrors it to a JSON file after
+// every change so it survives restarts.
+type Store struct {
+	mu   sync.Mutex
+	path string
+
+	Users    map[string]*User    `json:"users"`
+	Sessions map[string]*Session `json:"sessions"`
+
+	Categories []*Category       `json:"categories"`
+	Threads    map[int64]*Thread `json:"threads"`
+	Posts      map[int64][]*Post `json:"posts"`
+	LastID     int64             `json:"last_id"`
+
+	Messages []*Message `json:"messages"`
+
+	// Resets holds pending password resets, keyed like Sessions by the hash
+	// of their token.
+	Resets map[string]*PasswordReset `json:"resets"`
+
+	Reports []*Report `json:"reports"`
+}
+
+// OpenStore loads the store from path, starting empty if the file does not
+// exist yet.
+func OpenStore(path string) (*Store, error) {
+	s := &Store{path: path}
+
+	buf, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(buf, s); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.Users == nil {
+		s.Users = map[string]*User{}
+	}
+	if s.Sessions == nil {
+		s.Sessions = map[string]*Session{}
+	}
+	if s.Threads == nil {
+		s.Threads = map[int64]*Thread{}
+	}
+	if s.Posts == nil {
+		s.Posts = map[int64][]*Post{}
+	}
+	if s.Resets == nil {
+		s.Resets = map[string]*PasswordReset{}
+	}
+	if len(s.Categories) == 0 {
+		for _, c := range defaultCategories {
+			s.LastID++
+			s.Categories = append(s.Categories, &Category{ID: s.LastID, Name: c.Name, Description: c.Description})
+		}
+	}
+	return s, nil
+}
+
+// save writes the store to disk. The caller must hold s.mu.
+func (s *Store) save() error {
+	buf, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".secureforo-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(buf); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+func (s *Store) CreateUser(u User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.Users[u.Name]; ok {
+		return ErrUserExists
+	}
+	u.Created = time.Now().UTC()
+	s.Users[u.Name] = &u
+	return s.save()
+}
+
+// SetPublicKey replaces the user's message key, e.g. after logging in from a
+// new device.
+func (s *Store) SetPublicKey(name string, publicKey []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.Users[name]
+	if !ok {
+		return ErrUserNotFound
+	}
+	u.PublicKey = publicKey
+	return s.save()
+}
+
+// User returns a copy of the named user.
+func (s *Store) User(name string) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.Users[name]
+	if !ok {
+		return User{}, ErrUserNotFound
+	}
+	return *u, nil
+}
+
+// CreateSession issues a new random token for user, valid for ttl.
+func (s *Store) CreateSession(user string, ttl time.Duration) (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(raw)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pruneSessions()
+	s.Sessions[hashToken(token)] = &Session{User: user, Expires: time.Now().Add(ttl).UTC()}
+	return token, s.save()
+}
+
+// SessionUser returns the user owning token.
+func (s *Store) SessionUser(token string) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sess, ok := s.Sessions[hashToken(token)]
+	if !ok || time.Now().After(sess.Expires) {
+		return User{}, ErrNoSession
+	}
+	u, ok := s.Users[sess.User]
+	if !ok || u.Banned {
+		return User{}, ErrNoSession
+	}
+	return *u, nil
+}
+
+func (s *Store) DeleteSession(token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.Sessions, hashToken(token))
+	return s.save()
+}
+
+// nextID returns a fresh ID for a category, thread or post. The caller must
+// hold s.mu.
+func (s *Store) nextID() int64 {
+	s.LastID++
+	return s.LastID
+}
+
+// deleteUserSessions logs the user out everywhere. The caller must hold s.mu.
+func (s *Store) deleteUserSessions(name string) {
+	for k, sess := range s.Sessions {
+		if sess.User == name {
+			delete(s.Sessions, k)
+		}
+	}
+}
+
+// pruneSessions drops expired sessions. The caller must hold s.mu.
+func (s *Store) pruneSessions() {
+	now := time.Now()
+	for k, sess := range s.Sessions {
+		if now.After(sess.Expires) {
+			delete(s.Sessions, k)
+		}
+	}
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	totpIssuer = "SecureForo"
+	totpDigits = 6
+	totpPeriod = 30 * time.Second
+
+	// totpSkew is how many periods before or after the current one a code is
+	// still accepted, to tolerate clocks that drift apart.
+	totpSkew = 1
+
+	recoveryCodeCount = 10
+
+	challengeTTL         = 5 * time.Minute
+	maxChallengeAttempts = 5
+)
+
+var (
+	ErrInvalidCode    = errors.New("invalid verification code")
+	ErrTOTPNotPending = errors.New("two-factor setup was not started")
+)
+
+// TOTP is the two-factor state of an account (RFC 6238, HMAC-SHA1, 6 digits,
+// 30 second steps). LastStep is the time step of the last accepted code, so a
+// code cannot be replayed. Recovery codes are stored as SHA-256 hashes and are
+// single use.
+type TOTP struct {
+	Enabled       bool     `json:"enabled"`
+	Secret        []byte   `json:"secret,omitempty"`
+	Pending       []byte   `json:"pending,omitempty"`
+	LastStep      int64    `json:"last_step,omitempty"`
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
+}
+
+var base32NoPad = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// totpCode computes the code for a time step.
+func totpCode(secret []byte, step int64) string {
+	return hotp(secret, uint64(step), totpDigits)
+}
+
+// hotp computes a digits long one-time password for counter as described in
+// RFC 4226.
+func hotp(secret []byte, counter uint64, digits int) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	n := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+
+	mod := uint32(1)
+	for range digits {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, n%mod)
+}
+
+func totpStep(t time.Time) int64 {
+	return t.Unix() / int64(totpPeriod/time.Second)
+}
+
+// matchTOTP returns the step within the skew window whose code is code and
+// which is newer than lastStep.
+func matchTOTP(secret []byte, code string, now time.Time, lastStep int64) (int64, bool) {
+	current := totpStep(now)
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totpCode(secret, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// totpURI is the otpauth:// URI authenticator apps import, usually through a
+// QR code.
+func totpURI(name string, secret []byte) string {
+	v := url.Values{}
+	v.Set("secret", base32NoPad.EncodeToString(secret))
+	v.Set("issuer", totpIssuer)
+	v.Set("digits", fmt.Sprint(totpDigits))
+	v.Set("period", fmt.Sprint(int(totpPeriod/time.Second)))
+	return "otpauth://totp/" + url.PathEscape(totpIssuer+":"+name) + "?" + v.Encode()
+}
+
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	return b, err
+}
+
+// normalizeRecoveryCode lets users type recovery codes with or without the
+// dash, in any case.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.ReplaceAll(code, "-", "")
+}
+
+func hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(normalizeRecoveryCode(code)))
+	return hex.EncodeToString(sum[:])
+}
+
+func newRecoveryCodes() (codes, hashes []string, err error) {
+	for i := 0; i < recoveryCodeCount; i++ {
+		b, err := randomBytes(7)
+		if err != nil {
+			return nil, nil, err
+		}
+		c := strings.ToLower(base32NoPad.EncodeToString(b))[:10]
+		c = c[:5] + "-" + c[5:]
+
+		codes = append(codes, c)
+		hashes = append(hashes, hashRecoveryCode(c))
+	}
+	return codes, hashes, nil
+}
+
+// BeginTOTP generates a new secret for the user. It only takes effect once
+// EnableTOTP confirms the user's authenticator produces matching codes.
+func (s *Store) BeginTOTP(name string) ([]byte, error) {
+	secret, err := randomBytes(20)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.Users[name]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	u.TOTP.Pending = secret
+	return secret, s.save()
+}
+
+// EnableTOTP turns two-factor authentication on if code matches the pending
+// secret, and returns a fresh set of recovery codes.
+func (s *Store) EnableTOTP(name, code string, now time.Time) ([]string, error) {
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.Users[name]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	if u.TOTP.Pending == nil {
+		return nil, ErrTOTPNotPending
+	}
+
+	step, ok := matchTOTP(u.TOTP.Pending, code, now, 0)
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+
+	u.TOTP = TOTP{Enabled: true, Secret: u.TOTP.Pending, LastStep: step, RecoveryCodes: hashes}
+	return codes, s.save()
+}
+
+// CheckSecondFactor accepts either a current TOTP code that was not used
+// before or an unused recovery code, which is then consumed.
+func (s *Store) CheckSecondFactor(name, code string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.Users[name]
+	if !ok || !u.TOTP.Enabled {
+		return ErrInvalidCode
+	}
+
+	if step, ok := matchTOTP(u.TOTP.Secret, strings.TrimSpace(code), now, u.TOTP.LastStep); ok {
+		u.TOTP.LastStep = step
+		return s.save()
+	}
+
+	h := hashRecoveryCode(code)
+	for i, rc := range u.TOTP.RecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(rc), []byte(h)) == 1 {
+			u.TOTP.RecoveryCodes = append(u.TOTP.RecoveryCodes[:i], u.TOTP.RecoveryCodes[i+1:]...)
+			return s.save()
+		}
+	}
+	return ErrInvalidCode
+}
+
+func (s *Store) DisableTOTP(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.Users[name]
+	if !ok {
+		return ErrUserNotFound
+	}
+	u.TOTP = TOTP{}
+	return s.save()
+}
+
+// loginChallenge is a login that passed the password check and waits for the
+// second factor.
+type loginChallenge struct {
+	user     string
+	expires  time.Time
+	attempts int
+}
+
+func (s *Server) newChallenge(user string) (string, error) {
+	raw, err := randomBytes(32)
+	if err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(raw)
+
+	s.challengesMu.Lock()
+	defer s.challengesMu.Unlock()
+
+	now := time.Now()
+	for k, c := range s.challenges {
+		if now.After(c.expires) {
+			delete(s.challenges, k)
+		}
+	}
+	s.challenges[hashToken(token)] = &loginChallenge{user: user, expires: now.Add(challengeTTL)}
+	return token, nil
+}
+
+// challengeUser returns the user a pending challenge belongs to, counting the
+// attempt. Challenges are dropped once expired or after too many attempts.
+func (s *Server) challengeUser(token string) (string, bool) {
+	s.challengesMu.Lock()
+	defer s.challengesMu.Unlock()
+
+	key := hashToken(token)
+	c, ok := s.challenges[key]
+	if !ok {
+		return "", false
+	}
+
+	c.attempts++
+	if time.Now().After(c.expires) || c.attempts > maxChallengeAttempts {
+		delete(s.challenges, key)
+		return "", false
+	}
+	return c.user, true
+}
+
+func (s *Server) dropChallenge(token string) {
+	s.challengesMu.Lock()
+	defer s.challengesMu.Unlock()
+
+	delete(s.challenges, hashToken(token))
+}
+
+func (s *Server) handleLoginTOTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Challenge string `json:"challenge"`
+		Code      string `json:"code"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	name, ok := s.challengeUser(req.Challenge)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "login expired, please enter your password again")
+		return
+	}
+
+	ip := s.clientIP(r)
+	if !s.allowLogin(w, name, ip) {
+		return
+	}
+
+	if err := s.store.CheckSecondFactor(name, req.Code, time.Now()); err != nil {
+		s.loginFailed(w, name, ip, auditTOTPFailed, err.Error())
+		return
+	}
+	s.dropChallenge(req.Challenge)
+
+	user, err := s.store.User(name)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	if user.Banned {
+		s.audit.Record("login", name, ip, auditBanned)
+		writeError(w, http.StatusForbidden, ErrBanned.Error())
+		return
+	}
+	// Both the password step and this one reserved an attempt; take this
+	// one's back here and the password's in loginSucceeded.
+	s.limiter.Success(name, ip)
+	s.loginSucceeded(w, user, ip)
+}
+
+func (s *Server) handleTOTPStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]bool{"enabled": currentUser(r).TOTP.Enabled})
+}
+
+func (s *Server) handleTOTPSetup(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	if user.TOTP.Enabled {
+		writeError(w, http.StatusConflict, "two-factor authentication is already enabled")
+		return
+	}
+
+	secret, err := s.store.BeginTOTP(user.Name)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{
+		"secret": base32NoPad.EncodeToString(secret),
+		"uri":    totpURI(user.Name, secret),
+	})
+}
+
+func (s *Server) handleTOTPEnable(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Code string `json:"code"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	codes, err := s.store.EnableTOTP(currentUser(r).Name, strings.TrimSpace(req.Code), time.Now())
+	switch {
+	case errors.Is(err, ErrInvalidCode), errors.Is(err, ErrTOTPNotPending):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case err != nil:
+		internalError(w, err)
+	default:
+		writeJSON(w, http.StatusOK, map[string][]string{"recovery_codes": codes})
+	}
+}
+
+// handleTOTPDisable needs a valid code too, so a stolen session alone cannot
+// remove the second factor. The codes tried are throttled like those of a
+// login, so that they cannot be guessed either.
+func (s *Server) handleTOTPDisable(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Code string `json:"code"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	name, ip := currentUser(r).Name, s.clientIP(r)
+	if !s.allowLogin(w, name, ip) {
+		return
+	}
+	if err := s.store.CheckSecondFactor(name, req.Code, time.Now()); err != nil {
+		s.audit.Record("totp_disable", name, ip, auditTOTPFailed)
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	s.limiter.Success(name, ip)
+
+	if err := s.store.DisableTOTP(name); err != nil {
+		internalError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// rfcSecret is the SHA-1 key of the test vectors of RFC 4226 and RFC 6238.
+var rfcSecret = []byte("12345678901234567890")
+
+// TestHOTP checks the values of RFC 4226, Appendix D.
+func TestHOTP(t *testing.T) {
+	want := []string{
+		"755224", "287082", "359152", "969429", "338314",
+		"254676", "287922", "162583", "399871", "520489",
+	}
+	for counter, code := range want {
+		if got := hotp(rfcSecret, uint64(counter), 6); got != code {
+			t.Errorf("hotp(%d) = %s, want %s", counter, got, code)
+		}
+	}
+}
+
+// TestTOTP checks the SHA-1 values of RFC 6238, Appendix B, which have 8
+// digits, and that the 6 digit codes the server uses are their last digits.
+func TestTOTP(t *testing.T) {
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+	for _, tt := range tests {
+		step := totpStep(time.Unix(tt.unix, 0))
+		if got := hotp(rfcSecret, uint64(step), 8); got != tt.code {
+			t.Errorf("T=%d: hotp = %s, want %s", tt.unix, got, tt.code)
+		}
+		if got, want := totpCode(rfcSecret, step), tt.code[len(tt.code)-totpDigits:]; got != want {
+			t.Errorf("T=%d: totpCode = %s, want %s", tt.unix, got, want)
+		}
+	}
+}
+
+func TestMatchTOTP(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := totpStep(now)
+
+	for skew := -totpSkew; skew <= totpSkew; skew++ {
+		code := totpCode(rfcSecret, step+int64(skew))
+		if got, ok := matchTOTP(rfcSecret, code, now, 0); !ok || got != step+int64(skew) {
+			t.Errorf("skew %d: matchTOTP = %d, %v, want %d, true", skew, got, ok, step+int64(skew))
+		}
+	}
+
+	if _, ok := matchTOTP(rfcSecret, totpCode(rfcSecret, step+totpSkew+1), now, 0); ok {
+		t.Error("a code outside the skew window matched")
+	}
+	if _, ok := matchTOTP(rfcSecret, totpCode(rfcSecret, step), now, step); ok {
+		t.Error("a code of an already used step matched")
+	}
+}
+
+// enableTOTP turns two-factor authentication on for name and returns the
+// recovery codes.
+func enableTOTP(t *testing.T, s *Server, name string) []string {
+	t.Helper()
+
+	secret, err := s.store.BeginTOTP(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	codes, err := s.store.EnableTOTP(name, totpCode(secret, totpStep(now)), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return codes
+}
+
+// TestTOTPDisableThrottled checks that a stolen session cannot guess its way
+// to disabling two-factor authentication.
+func TestTOTPDisableThrottled(t *testing.T) {
+	s := newTestServer(t)
+	token := login(t, s, "alice")
+	codes := enableTOTP(t, s, "alice")
+
+	for i := 0; i <= accountPolicy.Free; i++ {
+		if w := do(t, s, "POST", "/totp/disable", token, `{"code": "000000"}`, nil); w.Code != http.StatusBadRequest {
+			t.Fatalf("guess %d: status %d, want 400", i+1, w.Code)
+		}
+	}
+	w := do(t, s, "POST", "/totp/disable", token, `{"code": "`+codes[0]+`"}`, nil)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("after %d wrong codes: status %d, want 429", accountPolicy.Free+1, w.Code)
+	}
+	if u, _ := s.store.User("alice"); !u.TOTP.Enabled {
+		t.Error("two-factor authentication disabled while throttled")
+	}
+}
+
+func TestTOTPDisable(t *testing.T) {
+	s := newTestServer(t)
+	token := login(t, s, "alice")
+	codes := enableTOTP(t, s, "alice")
+
+	if w := do(t, s, "POST", "/totp/disable", token, `{"code": "`+codes[0]+`"}`, nil); w.Code != http.StatusNoContent {
+		t.Fatalf("status %d, want 204: %s", w.Code, w.Body)
+	}
+	if u, _ := s.store.User("alice"); u.TOTP.Enabled {
+		t.Error("two-factor authentication still enabled")
+	}
+}
+
+// TestTOTPLoginNotThrottled logs in with a second factor more often than the
+// address may fail: successful logins must not add up to a lockout.
+func TestTOTPLoginNotThrottled(t *testing.T) {
+	s := newTestServer(t)
+	hash, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.store.CreateUser(User{Name: "alice", PasswordHash: hash}); err != nil {
+		t.Fatal(err)
+	}
+	enableTOTP(t, s, "alice")
+
+	// Enough recovery codes for every login.
+	n := ipPolicy.LockoutAfter + 1
+	var codes []string
+	s.store.mu.Lock()
+	u := s.store.Users["alice"]
+	for i := 0; i < n; i++ {
+		codes = append(codes, fmt.Sprintf("code-%d", i))
+		u.TOTP.RecoveryCodes = append(u.TOTP.RecoveryCodes, hashRecoveryCode(codes[i]))
+	}
+	s.store.mu.Unlock()
+
+	for i, code := range codes {
+		var challenge struct {
+			Challenge string `json:"challenge"`
+		}
+		if w := do(t, s, "POST", "/login", "", `{"name": "alice", "password": "correct horse"}`, &challenge); w.Code != http.StatusOK {
+			t.Fatalf("login %d: password step: status %d: %s", i+1, w.Code, w.Body)
+		}
+		body := fmt.Sprintf(`{"challenge": %q, "code": %q}`, challenge.Challenge, code)
+		if w := do(t, s, "POST", "/login/totp", "", body, nil); w.Code != http.StatusOK {
+			t.Fatalf("login %d: code step: status %d: %s", i+1, w.Code, w.Body)
+		}
+	}
+}
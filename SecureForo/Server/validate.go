@@ -0,0 +1,26 @@
+package main
+
+import "net/http"
+
+// The rules themselves are in rules.go, which the client shares.
+
+func validateRegistration(name, password, email string) fieldErrors {
+	errs := fieldErrors{}
+	if msg := validateName(name); msg != "" {
+		errs["name"] = msg
+	}
+	if msg := validatePassword(name, password); msg != "" {
+		errs["password"] = msg
+	}
+	if msg := validateEmail(email); msg != "" {
+		errs["email"] = msg
+	}
+	return errs
+}
+
+func writeFieldErrors(w http.ResponseWriter, errs fieldErrors) {
+	writeJSON(w, http.StatusBadRequest, map[string]interface{}{
+		"error":  "Please correct the highlighted fields.",
+		"fields": errs,
+	})
+}